@@ -69,6 +69,8 @@ func init() {
 		&closeTabsOther{},
 		&closeWindow{},
 		&openURLAction{},
+		&addToReadingList{},
+		&addToReadingList{close: true},
 	} {
 		if err := Register(a); err != nil {
 			panic(err)
@@ -0,0 +1,297 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	aw "github.com/deanishe/awgo"
+	safari "github.com/deanishe/go-safari"
+)
+
+// Kinds of audit finding. They are also the names of fixes.
+const (
+	findingEmpty       = "empty"
+	findingDeep        = "deep"
+	findingLarge       = "large"
+	findingTitle       = "title"
+	findingHTTP        = "https"
+	findingBookmarklet = "bookmarklet"
+)
+
+// Name of folder bookmarklets belong in.
+const bookmarkletsFolder = "Bookmarklets"
+
+// finding is a problem found by the bookmarks audit.
+type finding struct {
+	Kind     string
+	Desc     string
+	Folder   *safari.Folder   // folder with problem or bookmark's parent
+	Bookmark *safari.Bookmark // nil if Folder has problem
+}
+
+// fixable returns true if the workflow can fix the problem itself.
+func (f finding) fixable() bool {
+	switch f.Kind {
+	case findingEmpty, findingHTTP, findingBookmarklet:
+		return true
+	}
+	return false
+}
+
+// doAuditBookmarks shows problems with bookmarks and folders.
+func doAuditBookmarks() error {
+
+	showUpdateStatus()
+
+	log.Printf("query=%q, max-depth=%d, max-size=%d", query, auditMaxDepth, auditMaxSize)
+
+	// Read the same file that doFixBookmark changes
+	p, err := safari.New(safari.BookmarksPath(bookmarksPlist))
+	if err != nil {
+		return err
+	}
+
+	findings := auditBookmarks(p.Folders, auditMaxDepth, auditMaxSize)
+	log.Printf("%d problem(s) found", len(findings))
+
+	for _, fd := range findings {
+		findingItem(fd)
+	}
+
+	if query != "" {
+		res := wf.Filter(query)
+		log.Printf("%d problem(s) for %q", len(res), query)
+	}
+
+	wf.WarnEmpty("No problems found", "Try a different query?")
+	wf.SendFeedback()
+	return nil
+}
+
+// doFixBookmark fixes a problem found by the audit.
+func doFixBookmark() error {
+
+	wf.Configure(aw.TextErrors(true))
+
+	log.Printf("uid=%s, fix=%s", uid, fixKind)
+
+	bf, err := loadBookmarksFile(bookmarksPlist)
+	if err != nil {
+		return err
+	}
+
+	n := bf.Find(uid)
+	if n == nil {
+		return fmt.Errorf("Not found: %s", uid)
+	}
+
+	switch fixKind {
+
+	case findingEmpty:
+		if !n.IsFolder() || len(n.Children()) > 0 {
+			return fmt.Errorf("Not an empty folder: %s", n.Title())
+		}
+		bf.Remove(uid)
+
+	case findingHTTP:
+		// URL schemes are case-insensitive
+		URL := n.URL()
+		if len(URL) < 7 || !strings.EqualFold(URL[:7], "http://") {
+			return fmt.Errorf("Not an HTTP bookmark: %s", n.Title())
+		}
+		n["URLString"] = "https://" + URL[7:]
+
+	case findingBookmarklet:
+		var dest plistNode
+		bf.Root.Walk(func(n plistNode, _ []string) {
+			if dest == nil && n.IsFolder() && strings.EqualFold(n.Title(), bookmarkletsFolder) {
+				dest = n
+			}
+		})
+		if dest == nil {
+			if dest, err = bf.Folder(topLevelNames[bookmarksMenuName]+"/"+bookmarkletsFolder, true); err != nil {
+				return err
+			}
+		}
+		bf.Remove(uid)
+		dest.Append(n)
+
+	default:
+		return fmt.Errorf("Unknown fix: %s", fixKind)
+	}
+
+	if err := bf.Save(); err != nil {
+		return err
+	}
+	fmt.Printf("Fixed \"%s\"\n", n.Title())
+	return nil
+}
+
+// auditBookmarks checks folders and their bookmarks for problems.
+func auditBookmarks(folders []*safari.Folder, maxDepth, maxSize int) []finding {
+
+	var (
+		findings []finding
+		https    = map[string]bool{} // hosts with HTTPS bookmarks
+		insecure []finding
+	)
+
+	for _, f := range folders {
+		if f.Title() == topLevelNames[readingListName] {
+			continue
+		}
+
+		n := len(f.Bookmarks) + len(f.Folders)
+		if n == 0 && len(f.Ancestors) > 0 { // top-level folders may be empty
+			findings = append(findings, finding{Kind: findingEmpty, Desc: "Empty folder", Folder: f})
+		}
+		if len(f.Ancestors) > maxDepth {
+			findings = append(findings, finding{Kind: findingDeep,
+				Desc: fmt.Sprintf("Nested %d levels deep", len(f.Ancestors)), Folder: f})
+		}
+		if n > maxSize {
+			findings = append(findings, finding{Kind: findingLarge,
+				Desc: fmt.Sprintf("Contains %d items", n), Folder: f})
+		}
+
+		inBookmarklets := false
+		for _, s := range folderPath(f) {
+			if strings.EqualFold(s, bookmarkletsFolder) {
+				inBookmarklets = true
+			}
+		}
+
+		for _, bm := range f.Bookmarks {
+			if bm.IsBookmarklet() {
+				if !inBookmarklets {
+					findings = append(findings, finding{Kind: findingBookmarklet,
+						Desc: "Bookmarklet outside " + bookmarkletsFolder + " folder", Folder: f, Bookmark: bm})
+				}
+				continue
+			}
+
+			t := strings.TrimSpace(bm.Title())
+			if t == "" {
+				findings = append(findings, finding{Kind: findingTitle, Desc: "No title", Folder: f, Bookmark: bm})
+			} else if normaliseURL(t) == normaliseURL(bm.URL) {
+				findings = append(findings, finding{Kind: findingTitle, Desc: "URL as title", Folder: f, Bookmark: bm})
+			}
+
+			u, err := url.Parse(bm.URL)
+			if err != nil {
+				continue
+			}
+			switch u.Scheme {
+			case "https":
+				https[u.Hostname()] = true
+			case "http":
+				insecure = append(insecure, finding{Kind: findingHTTP, Desc: "Not HTTPS", Folder: f, Bookmark: bm})
+			}
+		}
+	}
+
+	// Only report HTTP bookmarks for sites known to support HTTPS
+	for _, fd := range insecure {
+		if u, _ := url.Parse(fd.Bookmark.URL); https[u.Hostname()] {
+			findings = append(findings, fd)
+		}
+	}
+
+	return findings
+}
+
+// findingItem returns a feedback Item for an audit finding. ↩ opens
+// the bookmark (browses the folder of a bookmarklet) or browses the
+// folder, ⌥↩ browses the bookmark's folder and ^↩ fixes the problem
+// (if possible). ⌘C copies a bookmark's URL.
+func findingItem(fd finding) *aw.Item {
+
+	var (
+		it   *aw.Item
+		path = strings.Join(folderPath(fd.Folder), " / ")
+	)
+
+	if fd.Bookmark != nil {
+		bm := fd.Bookmark
+		title := bm.Title()
+		if title == "" {
+			title = bm.URL
+		}
+		it = wf.NewItem(title).
+			Subtitle(fmt.Sprintf("%s · %s", fd.Desc, path)).
+			Match(fmt.Sprintf("%s %s %s", title, fd.Desc, fd.Kind)).
+			UID(fd.Kind+"-"+bm.UID()).
+			Copytext(bm.URL).
+			Icon(IconWarning).
+			Valid(true).
+			Var("ALSF_UID", bm.UID()).
+			Var("ALSF_ACTION", urlActionDefault).
+			Var("action", "open")
+
+		it.NewModifier("alt").
+			Subtitle(fmt.Sprintf("Browse \"%s\"", fd.Folder.Title())).
+			Icon(IconFolder).
+			Var("ALSF_UID", fd.Folder.UID()).
+			Var("action", "browse")
+
+		// Opening a bookmarklet runs it, so show it in its folder instead
+		if bm.IsBookmarklet() {
+			it.Var("ALSF_UID", fd.Folder.UID()).Var("action", "browse")
+		}
+
+	} else {
+		it = wf.NewItem(folderTitle(indexFolderFromSafari(fd.Folder))).
+			Subtitle(fmt.Sprintf("%s · %s", fd.Desc, path)).
+			Match(fmt.Sprintf("%s %s %s", fd.Folder.Title(), fd.Desc, fd.Kind)).
+			UID(fd.Kind+"-"+fd.Folder.UID()).
+			Icon(IconFolder).
+			Valid(true).
+			Var("ALSF_UID", fd.Folder.UID()).
+			Var("action", "browse")
+
+		if len(fd.Folder.Ancestors) > 0 {
+			p := fd.Folder.Ancestors[len(fd.Folder.Ancestors)-1]
+			it.NewModifier("alt").
+				Subtitle(fmt.Sprintf("Browse \"%s\"", p.Title())).
+				Icon(IconUp).
+				Var("ALSF_UID", p.UID()).
+				Var("action", "browse")
+		}
+	}
+
+	m := it.NewModifier("ctrl")
+	if !fd.fixable() {
+		m.Subtitle("Can't be fixed automatically").Valid(false)
+		return it
+	}
+
+	uid := fd.Folder.UID()
+	if fd.Bookmark != nil {
+		uid = fd.Bookmark.UID()
+	}
+	m.Subtitle(fixDescription(fd.Kind)).
+		Valid(true).
+		Var("ALSF_UID", uid).
+		Var("ALSF_FIX", fd.Kind).
+		Var("action", "fix")
+
+	return it
+}
+
+// fixDescription describes what fixing a problem does.
+func fixDescription(kind string) string {
+	switch kind {
+	case findingEmpty:
+		return "Delete folder"
+	case findingHTTP:
+		return "Change URL to HTTPS"
+	case findingBookmarklet:
+		return fmt.Sprintf("Move to \"%s\" folder", bookmarkletsFolder)
+	}
+	return ""
+}
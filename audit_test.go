@@ -0,0 +1,90 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	safari "github.com/deanishe/go-safari"
+)
+
+func TestAuditBookmarks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alsf-audit-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := copyFile(t, "Bookmarks.plist", dir)
+	bf, err := loadBookmarksFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	menu, err := bf.Folder("Bookmarks Menu", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	menu.Append(
+		newLeafNode("", "https://notitle.com/"),
+		newLeafNode("https://example.org", "https://example.org/"),
+		newLeafNode("Go", "http://golang.org/doc/"), // HTTPS bookmark in Favorites
+		newLeafNode("Old Site", "http://insecure.net/"),
+		newLeafNode("Alert", "javascript:alert(1)"),
+	)
+	if _, err := bf.Folder("Bookmarks Menu/Empty", true); err != nil {
+		t.Fatal(err)
+	}
+	deep, err := bf.Folder("Bookmarks Menu/One/Two/Three", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deep.Append(newLeafNode("Deep", "https://deep.com/"))
+	bkm, err := bf.Folder("Bookmarks Menu/Bookmarklets", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bkm.Append(newLeafNode("OK", "javascript:void(0)"))
+	if err := bf.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := safari.New(safari.BookmarksPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v []string
+	for _, fd := range auditBookmarks(p.Folders, 2, 7) {
+		// ↩ must not run bookmarklets
+		if fd.Kind == findingBookmarklet {
+			vars := findingItem(fd).Vars()
+			if vars["action"] != "browse" || vars["ALSF_UID"] != fd.Folder.UID() {
+				t.Errorf("Bookmarklet not browsed. Expected=browse %s, Got=%s %s",
+					fd.Folder.UID(), vars["action"], vars["ALSF_UID"])
+			}
+		}
+		s := fd.Kind + ": " + fd.Folder.Title()
+		if fd.Bookmark != nil {
+			s += "/" + fd.Bookmark.Title()
+		}
+		v = append(v, s)
+	}
+	sort.Strings(v)
+
+	x := []string{
+		"bookmarklet: Bookmarks Menu/Alert",
+		"deep: Three",
+		"empty: Empty",
+		"https: Bookmarks Menu/Go",
+		"large: Bookmarks Menu",
+		"title: Bookmarks Menu/",
+		"title: Bookmarks Menu/https://example.org",
+	}
+	if !reflect.DeepEqual(v, x) {
+		t.Errorf("Bad findings.\nExpected=%q\nGot=%q", x, v)
+	}
+}
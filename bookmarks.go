@@ -13,6 +13,8 @@ import (
 	"fmt"
 	"log"
 	"net/url"
+	"os/exec"
+	"strings"
 
 	aw "github.com/deanishe/awgo"
 	safari "github.com/deanishe/go-safari"
@@ -37,6 +39,11 @@ func doOpen() error {
 		// return openURL(uid)
 	}
 
+	// Open all of a device's cloud tabs
+	if name := deviceName(uid); name != "" {
+		return openCloudDevice(name, a)
+	}
+
 	// Find item with UID
 	log.Printf("Searching for %v ...", uid)
 
@@ -55,27 +62,44 @@ func doOpen() error {
 
 	if f := safari.FolderForUID(uid); f != nil {
 
-		errs := []error{}
+		bms := folderBookmarks(f, openRecursive)
+		if len(bms) > maxOpen && !openForce {
+			return fmt.Errorf("%d bookmarks in \"%s\" (limit is %d)", len(bms), f.Title(), maxOpen)
+		}
 
-		for _, bm := range f.Bookmarks {
-			log.Printf("Opening \"%s\" (%s) ...", bm.Title(), bm.URL)
+		var (
+			urls   []*url.URL
+			titles []string
+			errs   []error
+		)
+		for _, bm := range bms {
 			u, err := url.Parse(bm.URL)
 			if err != nil {
 				log.Printf("Invalid URL: %s: %v", bm.URL, err)
-				errs = append(errs, err)
+				errs = append(errs, fmt.Errorf("%s: %v", bm.Title(), err))
 				continue
 			}
-			if err := a.Run(u); err != nil {
-				log.Printf("Error opening bookmark: %v", err)
-				errs = append(errs, err)
+			urls = append(urls, u)
+			titles = append(titles, bm.Title())
+		}
+
+		if openNewWindow {
+			log.Printf("Opening %d bookmark(s) in new window ...", len(urls))
+			if err := openInNewWindow(urls); err != nil {
+				return err
 			}
+			return errorSummary(errs, len(bms))
 		}
 
-		if len(errs) > 0 {
-			return errs[0]
+		for i, u := range urls {
+			log.Printf("Opening %s ...", u)
+			if err := a.Run(u); err != nil {
+				log.Printf("Error opening bookmark: %v", err)
+				errs = append(errs, fmt.Errorf("%s: %v", titles[i], err))
+			}
 		}
 
-		return nil
+		return errorSummary(errs, len(bms))
 	}
 
 	return fmt.Errorf("Not found: %s", uid)
@@ -83,50 +107,75 @@ func doOpen() error {
 
 // Filter bookmarks and output Alfred results.
 func doFilterBookmarks() error {
-	return filterBookmarks(safari.FilterBookmarks(func(bm *safari.Bookmark) bool {
+	bms, err := indexBookmarks(func(bm *indexBookmark) bool {
+		if bm.ReadingList {
+			return false
+		}
 		if includeBookmarklets {
 			return true
 		}
-		return !bm.IsBookmarklet()
-	}))
+		return !bm.Bookmarklet
+	})
+	if err != nil {
+		return err
+	}
+	return filterBookmarks(bms, false)
 }
 
 // Filter bookmarklets and output Alfred results.
 func doFilterBookmarklets() error {
-	return filterBookmarks(safari.FilterBookmarks(func(bm *safari.Bookmark) bool {
-		return bm.IsBookmarklet()
-	}))
+	bms, err := indexBookmarks(func(bm *indexBookmark) bool { return bm.Bookmarklet })
+	if err != nil {
+		return err
+	}
+	return filterBookmarks(bms, false)
 }
 
-// Filter Safari's Reading List and sends results to Alfred.
-func doFilterReadingList() error { return filterBookmarks(safari.ReadingList().Bookmarks) }
-
-// filterBookmarks filters bookmarks and outputs Alfred results.
-func filterBookmarks(bookmarks []*safari.Bookmark) error {
+// filterBookmarks filters bookmarks and outputs Alfred results. If sorted
+// is true, bookmarks are already in the desired order, which is kept
+// when results are filtered.
+func filterBookmarks(bookmarks []*indexBookmark, sorted bool) error {
 
 	showUpdateStatus()
 
-	log.Printf("query=%q", query)
+	sq := parseQuery(query)
+	q := sq.Text
+	log.Printf("query=%q, operators=%q", q, sq)
 
 	log.Printf("Loaded %d bookmarks", len(bookmarks))
 
+	bookmarks = filterQuery(bookmarks, sq)
+
+	if bmSort != "" {
+		loadVisits(bookmarks)
+		bookmarks = sortByVisits(bookmarks, bmSort)
+		wf.Configure(aw.SuppressUIDs(true))
+		sorted = true
+	}
+
 	// Filter out duplicates (same title + URL)
-	seen := map[string]bool{}
+	var (
+		seen  = map[string]bool{}
+		order = map[*aw.Item]int{}
+	)
 
-	for _, bm := range bookmarks {
-		k := fmt.Sprintf("%s-%s", bm.Title(), bm.URL)
+	for i, bm := range bookmarks {
+		k := fmt.Sprintf("%s-%s", bm.RawTitle, bm.URL)
 		if _, dupe := seen[k]; !dupe {
-			bookmarkItem(bm)
+			order[bookmarkItem(bm)] = i
 			seen[k] = true
 		}
 	}
 
-	if query != "" {
-		res := wf.Filter(query)
-		log.Printf("%d bookmark(s) for %q", len(res), query)
+	if q != "" {
+		res := wf.Filter(q)
+		log.Printf("%d bookmark(s) for %q", len(res), q)
 		for i, r := range res {
 			log.Printf("#%02d %5.2f %q", i+1, r.Score, r.SortKey)
 		}
+		if sorted {
+			restoreOrder(order)
+		}
 	}
 
 	wf.WarnEmpty("No bookmarks found", "Try a different query?")
@@ -137,37 +186,122 @@ func filterBookmarks(bookmarks []*safari.Bookmark) error {
 // --------------------------------------------------------------------
 // Helpers
 
+// filterQuery returns the bookmarks that match the operators in sq.
+func filterQuery(bookmarks []*indexBookmark, sq *searchQuery) []*indexBookmark {
+	var matches []*indexBookmark
+	for _, bm := range bookmarks {
+		if sq.MatchBookmark(bm) {
+			matches = append(matches, bm)
+		}
+	}
+	return matches
+}
+
+// folderBookmarks returns the bookmarks in Folder f, excluding
+// bookmarklets. If recursive is true, the bookmarks in its
+// subfolders are also returned.
+func folderBookmarks(f *safari.Folder, recursive bool) []*safari.Bookmark {
+	var bms []*safari.Bookmark
+	for _, bm := range f.Bookmarks {
+		if !bm.IsBookmarklet() {
+			bms = append(bms, bm)
+		}
+	}
+	if recursive {
+		for _, f2 := range f.Folders {
+			bms = append(bms, folderBookmarks(f2, true)...)
+		}
+	}
+	return bms
+}
+
+// openInNewWindow opens URLs as tabs in a new Safari window.
+func openInNewWindow(urls []*url.URL) error {
+	if len(urls) == 0 {
+		return nil
+	}
+	script := `function run(argv) {
+	var safari = Application('Safari'),
+		doc = safari.Document().make(),
+		win = safari.windows[0];
+
+	doc.url = argv[0];
+	for (var i = 1; i < argv.length; i++) {
+		win.tabs.push(safari.Tab({url: argv[i]}));
+	}
+	safari.activate();
+}`
+	args := []string{"-l", "JavaScript", "-e", script}
+	for _, u := range urls {
+		args = append(args, u.String())
+	}
+	cmd := exec.Command("/usr/bin/osascript", args...)
+	log.Printf("%v", cmd)
+	return cmd.Run()
+}
+
+// errorSummary combines the errors from an operation on total items
+// into one error. It returns nil if errs is empty.
+func errorSummary(errs []error, total int) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return fmt.Errorf("%d of %d failed: %s", len(errs), total, strings.Join(msgs, "; "))
+}
+
 // bmURLer implements URLer for a Bookmark.
 type bmURLer struct {
-	bm *safari.Bookmark
+	bm *indexBookmark
 }
 
-// Implement URLer
-func (b *bmURLer) Title() string    { return b.bm.Title() }
-func (b *bmURLer) Subtitle() string { return b.bm.URL }
+// Implement URLer. #tags are removed from the title and shown in the subtitle.
+func (b *bmURLer) Title() string    { return b.bm.Title }
+func (b *bmURLer) Subtitle() string {
+	if b.bm.ReadingList {
+		return readingListSubtitle(b.bm)
+	}
+	if bmVisits != nil && !b.bm.Bookmarklet {
+		return tagSubtitle(b.bm.Tags, bmVisits[b.bm.URL].String()+" · "+b.bm.URL)
+	}
+	return tagSubtitle(b.bm.Tags, b.bm.URL)
+}
 func (b *bmURLer) URL() string      { return b.bm.URL }
-func (b *bmURLer) UID() string      { return b.bm.UID() }
+func (b *bmURLer) UID() string      { return b.bm.UID }
+func (b *bmURLer) Keywords() string { return b.bm.Keywords }
 func (b *bmURLer) Copytext() string {
-	if b.bm.IsBookmarklet() {
-		return "bkm:" + b.bm.UID()
+	if b.bm.Bookmarklet {
+		return "bkm:" + b.bm.UID
 	}
 	return b.bm.URL
 }
 func (b *bmURLer) Largetype() string {
-	if b.bm.InReadingList() {
+	if b.bm.ReadingList {
 		return b.bm.Preview
 	}
 	return b.bm.URL
 }
 func (b *bmURLer) Icon() *aw.Icon {
-	if b.bm.IsBookmarklet() {
+	if b.bm.Bookmarklet {
 		return IconBookmarklet
 	}
-	if b.bm.InReadingList() {
+	if b.bm.ReadingList {
 		return IconReadingList
 	}
 	return IconBookmark
 }
 
 // bookmarkItem returns a feedback Item for Safari Bookmark.
-func bookmarkItem(bm *safari.Bookmark) *aw.Item { return URLerItem(&bmURLer{bm}) }
+func bookmarkItem(bm *indexBookmark) *aw.Item {
+	it := URLerItem(&bmURLer{bm})
+	if bm.ReadingList {
+		markReadModifier(it, bm)
+	}
+	return it
+}
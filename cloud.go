@@ -6,29 +6,61 @@ package main
 import (
 	"fmt"
 	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
 
 	aw "github.com/deanishe/awgo"
 	"github.com/deanishe/go-safari/cloud"
 )
 
+// UIDs of cloud devices start with this prefix, so doOpen can open
+// all of a device's tabs.
+const cloudDeviceUIDPrefix = "cloud-device:"
+
+// cloudDevice is a device with cloud tabs.
+type cloudDevice struct {
+	Name     string
+	Tabs     []*cloud.Tab
+	LastSync time.Time // zero if unknown
+}
+
+// doFilterCloudTabs shows the devices with cloud tabs or, if the query
+// contains device: or other text, the matching tabs.
 func doFilterCloudTabs() error {
 
 	showUpdateStatus()
 
-	tabs, err := cloud.Tabs()
+	dq := parseDeviceQuery(query)
+	log.Printf("query=%q, devices=%v", dq.Text, dq.Devices)
+
+	devices, err := cloudDevices()
 	if err != nil {
 		return err
 	}
+	log.Printf("%d cloud device(s)", len(devices))
 
-	log.Printf("%d cloud tab(s)", len(tabs))
-
-	for _, t := range tabs {
-		URLerItem(&cloudTabURLer{t})
+	switch {
+	case len(dq.Devices) > 0:
+		deviceTabs(devices, dq)
+	case dq.Text == "":
+		// Keep Alfred from re-ordering devices based on usage
+		wf.Configure(aw.SuppressUIDs(true))
+		for _, d := range devices {
+			deviceItem(d)
+		}
+	default: // search all tabs
+		for _, d := range devices {
+			for _, t := range d.Tabs {
+				URLerItem(&cloudTabURLer{tab: t})
+			}
+		}
 	}
 
-	if query != "" {
-		res := wf.Filter(query)
-		log.Printf("%d cloud tab(s) for %q", len(res), query)
+	if dq.Text != "" {
+		res := wf.Filter(dq.Text)
+		log.Printf("%d cloud tab(s) for %q", len(res), dq.Text)
 		for i, r := range res {
 			log.Printf("#%02d %5.2f %q", i+1, r.Score, r.SortKey)
 		}
@@ -40,14 +72,292 @@ func doFilterCloudTabs() error {
 	return nil
 }
 
+// deviceQuery is a cloud tabs query: text and device: operators.
+type deviceQuery struct {
+	Text    string   // query minus operators
+	Devices []string // device: values
+}
+
+// parseDeviceQuery parses a cloud tabs query. Device names containing
+// spaces must be quoted, e.g. device:"Dean's iPad".
+func parseDeviceQuery(s string) *deviceQuery {
+	q := &deviceQuery{}
+	var words []string
+	for _, tok := range tokenizeQuery(s) {
+		if len(tok) > 7 && strings.EqualFold(tok[:7], "device:") {
+			q.Devices = append(q.Devices, strings.Trim(tok[7:], `"`))
+			continue
+		}
+		words = append(words, tok)
+	}
+	q.Text = strings.Join(words, " ")
+	return q
+}
+
+// deviceOperator returns the device: operator for the named device.
+func deviceOperator(name string) string {
+	if strings.ContainsAny(name, " \t") {
+		name = `"` + name + `"`
+	}
+	return "device:" + name
+}
+
+// matchDevice returns true if the query has no devices or one of them
+// is (case-insensitively) part of name, e.g. device:ipad matches
+// "Dean's iPad".
+func (q *deviceQuery) matchDevice(name string) bool {
+	if len(q.Devices) == 0 {
+		return true
+	}
+	name = strings.ToLower(name)
+	for _, d := range q.Devices {
+		if strings.Contains(name, strings.ToLower(d)) {
+			return true
+		}
+	}
+	return false
+}
+
+// deviceTabs adds items for the tabs on devices matching the query.
+func deviceTabs(devices []*cloudDevice, dq *deviceQuery) {
+
+	var matched []*cloudDevice
+	for _, d := range devices {
+		if dq.matchDevice(d.Name) {
+			matched = append(matched, d)
+		}
+	}
+
+	if dq.Text == "" {
+		wf.Configure(aw.SuppressUIDs(true))
+		wf.NewItem("Back to All Devices").
+			Autocomplete("").
+			Icon(IconHome).
+			Valid(false)
+
+		for _, d := range matched {
+			n := len(d.Tabs)
+			if n <= maxOpen {
+				deviceItem(d).
+					Title(fmt.Sprintf("Open All %d Tabs on %s", n, d.Name)).
+					Autocomplete("").
+					Valid(true)
+				continue
+			}
+
+			// Confirmation for devices with more tabs than the limit
+			it := wf.NewItem(fmt.Sprintf("Open All %d Tabs on %s?", n, d.Name)).
+				Subtitle(fmt.Sprintf("\"%s\" has more than %d tabs", d.Name, maxOpen)).
+				Icon(IconWarning).
+				Valid(true).
+				Var("ALSF_UID", cloudDeviceUIDPrefix+d.Name).
+				Var("ALSF_ACTION", urlActionDefault).
+				Var("ALSF_FORCE", "1").
+				Var("action", "open")
+
+			it.NewModifier("ctrl").
+				Subtitle(fmt.Sprintf("Open all %d tabs in New Window", n)).
+				Var("ALSF_NEW_WINDOW", "1").
+				Var("action", "open")
+		}
+	}
+
+	for _, d := range matched {
+		for _, t := range d.Tabs {
+			URLerItem(&cloudTabURLer{tab: t, device: true})
+		}
+	}
+}
+
+// deviceItem adds an item for a device. ↩ shows its tabs, ⌘↩ opens them
+// and ⌃↩ opens them in a new window.
+func deviceItem(d *cloudDevice) *aw.Item {
+
+	sub := plural(len(d.Tabs), "tab")
+	if !d.LastSync.IsZero() {
+		sub += " · Last synced " + relativeTime(d.LastSync)
+	}
+
+	it := wf.NewItem(d.Name).
+		Subtitle(sub).
+		Autocomplete(deviceOperator(d.Name)+" ").
+		UID(cloudDeviceUIDPrefix+d.Name).
+		Icon(IconCloud).
+		Valid(false).
+		Var("ALSF_UID", cloudDeviceUIDPrefix+d.Name).
+		Var("ALSF_ACTION", urlActionDefault).
+		Var("action", "open")
+
+	var (
+		n   = len(d.Tabs)
+		m   = it.NewModifier("cmd")
+		mNW = it.NewModifier("ctrl")
+	)
+	m.Subtitle(fmt.Sprintf("Open %d tab(s)", n)).Valid(true)
+	mNW.Subtitle(fmt.Sprintf("Open %d tab(s) in New Window", n)).
+		Valid(true).
+		Var("ALSF_NEW_WINDOW", "1")
+
+	// Too many tabs: --force is required, so show the device's tabs
+	// (with a confirmation item) instead
+	if n > maxOpen {
+		m.Subtitle(fmt.Sprintf("Open %d tab(s)? (more than %d)", n, maxOpen)).Valid(false)
+		mNW.Subtitle(fmt.Sprintf("Open %d tab(s) in New Window? (more than %d)", n, maxOpen)).Valid(false)
+	}
+	return it
+}
+
+// cloudDevices returns the devices with cloud tabs, sorted by name.
+func cloudDevices() ([]*cloudDevice, error) {
+
+	tabs, err := cloud.Tabs()
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("%d cloud tab(s)", len(tabs))
+
+	var (
+		synced  = cloudSyncTimes()
+		devices []*cloudDevice
+		byName  = map[string]*cloudDevice{}
+	)
+	// Tabs are sorted by device
+	for _, t := range tabs {
+		d, ok := byName[t.Device]
+		if !ok {
+			d = &cloudDevice{Name: t.Device, LastSync: synced[t.Device]}
+			byName[t.Device] = d
+			devices = append(devices, d)
+		}
+		d.Tabs = append(d.Tabs, t)
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return strings.ToLower(devices[i].Name) < strings.ToLower(devices[j].Name)
+	})
+	return devices, nil
+}
+
+// cloudSyncTimes returns the times devices last synced their tabs. Older
+// versions of Safari don't record them, in which case the map is empty.
+func cloudSyncTimes() map[string]time.Time {
+
+	synced := map[string]time.Time{}
+	db, err := openSQLite(cloud.DefaultTabsPath, true)
+	if err != nil {
+		log.Printf("[cloud] couldn't open database: %v", err)
+		return synced
+	}
+	defer db.Close()
+
+	var n int
+	err = db.QueryRow(`
+		SELECT COUNT(*) FROM pragma_table_info('cloud_tab_devices')
+		WHERE name = 'last_modified'`).Scan(&n)
+	if err != nil || n == 0 {
+		log.Printf("[cloud] device sync times not available")
+		return synced
+	}
+
+	rows, err := db.Query("SELECT device_name, last_modified FROM cloud_tab_devices WHERE last_modified IS NOT NULL")
+	if err != nil {
+		log.Printf("[cloud] couldn't read sync times: %v", err)
+		return synced
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			name string
+			ts   float64
+		)
+		if err := rows.Scan(&name, &ts); err != nil {
+			log.Printf("[cloud] couldn't read sync time: %v", err)
+			return synced
+		}
+		if t := fromCoreData(ts); t.After(synced[name]) {
+			synced[name] = t
+		}
+	}
+	return synced
+}
+
+// openCloudDevice opens all the tabs of the named device.
+func openCloudDevice(name string, a URLActionable) error {
+
+	devices, err := cloudDevices()
+	if err != nil {
+		return err
+	}
+
+	var d *cloudDevice
+	for _, dev := range devices {
+		if dev.Name == name {
+			d = dev
+			break
+		}
+	}
+	if d == nil {
+		return fmt.Errorf("No tabs on device %q", name)
+	}
+	if len(d.Tabs) > maxOpen && !openForce {
+		return fmt.Errorf("%d tabs on \"%s\" (limit is %d)", len(d.Tabs), d.Name, maxOpen)
+	}
+
+	var (
+		urls []*url.URL
+		errs []error
+	)
+	for _, t := range d.Tabs {
+		u, err := url.Parse(t.URL)
+		if err != nil {
+			log.Printf("Invalid URL: %s: %v", t.URL, err)
+			errs = append(errs, fmt.Errorf("%s: %v", t.Title, err))
+			continue
+		}
+		urls = append(urls, u)
+	}
+
+	if openNewWindow {
+		log.Printf("Opening %d tab(s) from %q in new window ...", len(urls), d.Name)
+		if err := openInNewWindow(urls); err != nil {
+			return err
+		}
+		return errorSummary(errs, len(d.Tabs))
+	}
+
+	for _, u := range urls {
+		log.Printf("Opening %s ...", u)
+		if err := a.Run(u); err != nil {
+			log.Printf("Error opening tab: %v", err)
+			errs = append(errs, fmt.Errorf("%s: %v", u, err))
+		}
+	}
+	return errorSummary(errs, len(d.Tabs))
+}
+
 type cloudTabURLer struct {
-	tab *cloud.Tab
+	tab    *cloud.Tab
+	device bool // showing a single device's tabs
 }
 
-func (u *cloudTabURLer) Title() string     { return u.tab.Title }
-func (u *cloudTabURLer) Subtitle() string  { return fmt.Sprintf("%s // %s", u.tab.Device, u.tab.URL) }
+func (u *cloudTabURLer) Title() string { return u.tab.Title }
+func (u *cloudTabURLer) Subtitle() string {
+	if u.device {
+		return u.tab.URL
+	}
+	return fmt.Sprintf("%s // %s", u.tab.Device, u.tab.URL)
+}
 func (u *cloudTabURLer) URL() string       { return u.tab.URL }
 func (u *cloudTabURLer) UID() string       { return u.tab.URL }
 func (u *cloudTabURLer) Copytext() string  { return u.tab.URL }
 func (u *cloudTabURLer) Largetype() string { return u.tab.URL }
 func (u *cloudTabURLer) Icon() *aw.Icon    { return IconCloud }
+
+// deviceName returns the name of the device whose UID is uid, or "" if
+// uid isn't a device UID.
+func deviceName(uid string) string {
+	if !strings.HasPrefix(uid, cloudDeviceUIDPrefix) {
+		return ""
+	}
+	return strings.TrimPrefix(uid, cloudDeviceUIDPrefix)
+}
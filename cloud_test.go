@@ -0,0 +1,68 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDeviceQuery(t *testing.T) {
+	tests := []struct {
+		in      string
+		text    string
+		devices []string
+	}{
+		{"", "", nil},
+		{"golang docs", "golang docs", nil},
+		{"device:ipad", "", []string{"ipad"}},
+		{"Device:iPad golang", "golang", []string{"iPad"}},
+		{`device:"Dean's iPad" go`, "go", []string{"Dean's iPad"}},
+		{"device:ipad device:mac", "", []string{"ipad", "mac"}},
+		// Incomplete operators are text
+		{"device:", "device:", nil},
+		{"mydevice:ipad", "mydevice:ipad", nil},
+	}
+	for _, td := range tests {
+		q := parseDeviceQuery(td.in)
+		if q.Text != td.text {
+			t.Errorf("Bad text for %q. Expected=%q, Got=%q", td.in, td.text, q.Text)
+		}
+		if !reflect.DeepEqual(q.Devices, td.devices) {
+			t.Errorf("Bad devices for %q. Expected=%q, Got=%q", td.in, td.devices, q.Devices)
+		}
+	}
+}
+
+func TestMatchDevice(t *testing.T) {
+	tests := []struct {
+		query, name string
+		x           bool
+	}{
+		{"", "Dean's iPad", true},
+		{"golang", "Dean's iPad", true},
+		{"device:ipad", "Dean's iPad", true},
+		{"device:IPAD", "Dean's iPad", true},
+		{`device:"dean's ipad"`, "Dean's iPad", true},
+		{"device:iphone", "Dean's iPad", false},
+		{"device:iphone device:ipad", "Dean's iPad", true},
+		{"device:mac", "Dean's MacBook Pro", true},
+		{`device:"Dean's iPad"`, "Dean's iPhone", false},
+	}
+	for _, td := range tests {
+		if v := parseDeviceQuery(td.query).matchDevice(td.name); v != td.x {
+			t.Errorf("Bad match of %q for %q. Expected=%v, Got=%v", td.name, td.query, td.x, v)
+		}
+	}
+}
+
+func TestDeviceOperator(t *testing.T) {
+	// Operators round-trip through parseDeviceQuery
+	for _, name := range []string{"iPad", "Dean's iPad", "Mac\tmini"} {
+		q := parseDeviceQuery(deviceOperator(name) + " go")
+		if !reflect.DeepEqual(q.Devices, []string{name}) || q.Text != "go" {
+			t.Errorf("Bad round-trip of %q: %q, %q", name, q.Devices, q.Text)
+		}
+	}
+}
@@ -37,6 +37,18 @@ func doConfig() error {
 		Icon(IconBlacklist).
 		Var("action", "open")
 
+	sfPath, err := initSmartFolders()
+	if err != nil {
+		return err
+	}
+
+	wf.NewItem("Edit Smart Folders").
+		Subtitle("Open smart folder definitions in your editor").
+		Arg(sfPath).
+		Valid(true).
+		Icon(IconSmartFolder).
+		Var("action", "open")
+
 	wf.NewItem("User Scripts").
 		Subtitle("Open user scripts directory in Finder").
 		Arg(filepath.Join(wf.DataDir(), "scripts")).
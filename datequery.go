@@ -0,0 +1,214 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// Time of day range, e.g. "@9am-11am", "@14:00-16:30" or "@9-11"
+	timeRangeRx = regexp.MustCompile(`^@(\d{1,2}(?::\d{2})?(?:am|pm)?)-(\d{1,2}(?::\d{2})?(?:am|pm)?)$`)
+	clockRx     = regexp.MustCompile(`^(\d{1,2})(?::(\d{2}))?(am|pm)?$`)
+	daysAgoRx   = regexp.MustCompile(`^(\d+)d$`)
+)
+
+// dateRange is a time constraint parsed from a query. Zero values mean
+// "no constraint".
+type dateRange struct {
+	From, To time.Time // visits in [From, To)
+	// Time of day as "HH:MM" (local time). If End is before Start, the
+	// range wraps around midnight.
+	Start, End string
+	Tokens     []string // date tokens from the query
+}
+
+// IsZero returns true if the range has no constraints.
+func (dr dateRange) IsZero() bool {
+	return dr.From.IsZero() && dr.To.IsZero() && dr.Start == ""
+}
+
+// String describes the range for logging.
+func (dr dateRange) String() string {
+	var s []string
+	if !dr.From.IsZero() {
+		s = append(s, "from "+dr.From.Format("2006-01-02 15:04"))
+	}
+	if !dr.To.IsZero() {
+		s = append(s, "before "+dr.To.Format("2006-01-02 15:04"))
+	}
+	if dr.Start != "" {
+		s = append(s, fmt.Sprintf("between %s and %s", dr.Start, dr.End))
+	}
+	if len(s) == 0 {
+		return "any time"
+	}
+	return strings.Join(s, ", ")
+}
+
+// parseDateQuery removes date tokens from query and returns the remaining
+// text and the date range the tokens specify. Understood tokens are:
+//
+//	today, yesterday           That day
+//	this week, this month      Since the start of the week (Monday)/month
+//	last week, last month      The last 7/30 days
+//	since:DAY, before:DAY      From the start of/before DAY, which may be
+//	                           YYYY-MM-DD, today, yesterday, a weekday
+//	                           (the most recent one) or Nd (N days ago)
+//	@9am-11am, @14:00-16:30    Time of day
+func parseDateQuery(query string, now time.Time) (string, dateRange) {
+
+	var (
+		dr    dateRange
+		words []string
+		today = startOfDay(now)
+		toks  = strings.Fields(query)
+	)
+
+	for i := 0; i < len(toks); i++ {
+		tok := strings.ToLower(toks[i])
+		next := ""
+		if i+1 < len(toks) {
+			next = strings.ToLower(toks[i+1])
+		}
+
+		switch {
+		case tok == "today":
+			dr.From = today
+			dr.Tokens = append(dr.Tokens, toks[i])
+			continue
+
+		case tok == "yesterday":
+			dr.From, dr.To = today.AddDate(0, 0, -1), today
+			dr.Tokens = append(dr.Tokens, toks[i])
+			continue
+
+		case (tok == "this" || tok == "last") && (next == "week" || next == "month"):
+			dr.Tokens = append(dr.Tokens, toks[i], toks[i+1])
+			i++
+			switch tok + " " + next {
+			case "this week":
+				dr.From = today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+			case "this month":
+				dr.From = today.AddDate(0, 0, 1-today.Day())
+			case "last week":
+				dr.From = today.AddDate(0, 0, -7)
+			case "last month":
+				dr.From = today.AddDate(0, 0, -30)
+			}
+			continue
+
+		case strings.HasPrefix(tok, "since:"):
+			if t, ok := parseDay(strings.TrimPrefix(tok, "since:"), today); ok {
+				dr.From = t
+				dr.Tokens = append(dr.Tokens, toks[i])
+				continue
+			}
+
+		case strings.HasPrefix(tok, "before:"):
+			if t, ok := parseDay(strings.TrimPrefix(tok, "before:"), today); ok {
+				dr.To = t
+				dr.Tokens = append(dr.Tokens, toks[i])
+				continue
+			}
+
+		case timeRangeRx.MatchString(tok):
+			m := timeRangeRx.FindStringSubmatch(tok)
+			start, ok1 := parseClock(m[1])
+			end, ok2 := parseClock(m[2])
+			if ok1 && ok2 {
+				dr.Start, dr.End = start, end
+				dr.Tokens = append(dr.Tokens, toks[i])
+				continue
+			}
+		}
+
+		words = append(words, toks[i])
+	}
+
+	return strings.Join(words, " "), dr
+}
+
+// parseDay parses a day for since: and before: and returns its start.
+func parseDay(s string, today time.Time) (time.Time, bool) {
+	switch s {
+	case "today":
+		return today, true
+	case "yesterday":
+		return today.AddDate(0, 0, -1), true
+	}
+
+	if m := daysAgoRx.FindStringSubmatch(s); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		return today.AddDate(0, 0, -n), true
+	}
+
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if s == name || s == name[:3] {
+			diff := (int(today.Weekday()) - int(d) + 7) % 7
+			return today.AddDate(0, 0, -diff), true
+		}
+	}
+
+	if t, err := time.ParseInLocation("2006-01-02", s, today.Location()); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// parseClock parses a time such as "9", "9am", "9:30pm" or "21:30" and
+// returns it as "HH:MM".
+func parseClock(s string) (string, bool) {
+	m := clockRx.FindStringSubmatch(s)
+	if m == nil {
+		return "", false
+	}
+	h, _ := strconv.Atoi(m[1])
+	min := 0
+	if m[2] != "" {
+		min, _ = strconv.Atoi(m[2])
+	}
+	switch m[3] {
+	case "am":
+		if h == 12 {
+			h = 0
+		}
+	case "pm":
+		if h < 12 {
+			h += 12
+		}
+	}
+	if h > 24 || min > 59 {
+		return "", false
+	}
+	return fmt.Sprintf("%02d:%02d", h, min), true
+}
+
+// startOfDay returns midnight at the start of t's day.
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// visitTime formats the time of a history visit for subtitles.
+func visitTime(t time.Time) string {
+	today := startOfDay(time.Now())
+	switch {
+	case !t.Before(today):
+		return "Today " + t.Format("15:04")
+	case !t.Before(today.AddDate(0, 0, -1)):
+		return "Yesterday " + t.Format("15:04")
+	case !t.Before(today.AddDate(0, 0, -6)):
+		return t.Format("Monday 15:04")
+	case t.Year() == today.Year():
+		return t.Format("2 Jan 15:04")
+	default:
+		return t.Format("2 Jan 2006 15:04")
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseDateQuery(t *testing.T) {
+	var (
+		now = time.Date(2019, 10, 16, 15, 4, 5, 0, time.UTC) // a Wednesday
+		day = func(d int) time.Time { return time.Date(2019, 10, d, 0, 0, 0, 0, time.UTC) }
+	)
+
+	tests := []struct {
+		in   string
+		text string
+		x    dateRange
+	}{
+		{"", "", dateRange{}},
+		{"golang", "golang", dateRange{}},
+		{"today", "", dateRange{From: day(16), Tokens: []string{"today"}}},
+		{"Yesterday go", "go", dateRange{From: day(15), To: day(16), Tokens: []string{"Yesterday"}}},
+		{"go this week", "go", dateRange{From: day(14), Tokens: []string{"this", "week"}}},
+		{"this month", "", dateRange{From: day(1), Tokens: []string{"this", "month"}}},
+		{"last week", "", dateRange{From: day(9), Tokens: []string{"last", "week"}}},
+		{"last month", "", dateRange{From: time.Date(2019, 9, 16, 0, 0, 0, 0, time.UTC), Tokens: []string{"last", "month"}}},
+		// "this"/"last" are text if not followed by "week" or "month"
+		{"last call", "last call", dateRange{}},
+		{"this", "this", dateRange{}},
+		{"since:2019-10-01", "", dateRange{From: day(1), Tokens: []string{"since:2019-10-01"}}},
+		{"since:3d", "", dateRange{From: day(13), Tokens: []string{"since:3d"}}},
+		{"since:mon", "", dateRange{From: day(14), Tokens: []string{"since:mon"}}},
+		{"since:wednesday", "", dateRange{From: day(16), Tokens: []string{"since:wednesday"}}},
+		{"before:yesterday", "", dateRange{To: day(15), Tokens: []string{"before:yesterday"}}},
+		{"since:fri before:today x", "x", dateRange{From: day(11), To: day(16),
+			Tokens: []string{"since:fri", "before:today"}}},
+		// Invalid days are text
+		{"since:soon", "since:soon", dateRange{}},
+		{"before:2019-13-01", "before:2019-13-01", dateRange{}},
+		{"@9am-11am", "", dateRange{Start: "09:00", End: "11:00", Tokens: []string{"@9am-11am"}}},
+		{"@14:00-16:30 go", "go", dateRange{Start: "14:00", End: "16:30", Tokens: []string{"@14:00-16:30"}}},
+		{"@10pm-2am", "", dateRange{Start: "22:00", End: "02:00", Tokens: []string{"@10pm-2am"}}},
+		{"@12am-12pm", "", dateRange{Start: "00:00", End: "12:00", Tokens: []string{"@12am-12pm"}}},
+		{"@9-25", "@9-25", dateRange{}},
+		{"@9:75-10", "@9:75-10", dateRange{}},
+		{"email@9-11", "email@9-11", dateRange{}},
+	}
+
+	for _, td := range tests {
+		text, dr := parseDateQuery(td.in, now)
+		if text != td.text {
+			t.Errorf("Bad text for %q. Expected=%q, Got=%q", td.in, td.text, text)
+		}
+		if !reflect.DeepEqual(dr, td.x) {
+			t.Errorf("Bad range for %q. Expected=%+v, Got=%+v", td.in, td.x, dr)
+		}
+		if dr.IsZero() != (len(td.x.Tokens) == 0) {
+			t.Errorf("Bad IsZero for %q: %v", td.in, dr.IsZero())
+		}
+	}
+}
+
+func TestParseClock(t *testing.T) {
+	tests := []struct {
+		in string
+		x  string
+		ok bool
+	}{
+		{"9", "09:00", true},
+		{"9am", "09:00", true},
+		{"9:30pm", "21:30", true},
+		{"21:30", "21:30", true},
+		{"12pm", "12:00", true},
+		{"12am", "00:00", true},
+		{"25", "", false},
+		{"9:60", "", false},
+		{"noon", "", false},
+	}
+	for _, td := range tests {
+		v, ok := parseClock(td.in)
+		if v != td.x || ok != td.ok {
+			t.Errorf("Bad clock for %q. Expected=%q/%v, Got=%q/%v", td.in, td.x, td.ok, v, ok)
+		}
+	}
+}
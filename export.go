@@ -0,0 +1,406 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	aw "github.com/deanishe/awgo"
+	"github.com/pkg/errors"
+)
+
+// Export formats.
+const (
+	formatMarkdown   = "markdown"
+	formatPocket     = "pocket-html"
+	formatInstapaper = "instapaper-csv"
+	formatJSON       = "json"
+	formatCSV        = "csv"
+	formatNDJSON     = "ndjson"
+)
+
+// Characters that aren't allowed in note filenames.
+var badFilenameRx = regexp.MustCompile(`[/\\:*?"<>|#^\[\]]+`)
+
+// exportEntry is a Reading List entry in JSON exports.
+type exportEntry struct {
+	Title          string     `json:"title"`
+	URL            string     `json:"url"`
+	DateAdded      time.Time  `json:"dateAdded"`
+	Read           bool       `json:"read"`
+	DateLastViewed *time.Time `json:"dateLastViewed,omitempty"`
+	Preview        string     `json:"preview,omitempty"`
+}
+
+// exportVisit is a history visit in JSON exports.
+type exportVisit struct {
+	Title        string    `json:"title"`
+	URL          string    `json:"url"`
+	Host         string    `json:"host"`
+	VisitTime    time.Time `json:"visitTime"`
+	VisitCount   int       `json:"visitCount"`
+	RedirectFrom string    `json:"redirectFrom,omitempty"`
+	RedirectTo   string    `json:"redirectTo,omitempty"`
+}
+
+// doExportReadingList writes the Reading List to a file (or STDOUT) or,
+// with --notes, to a directory with one Markdown note per entry.
+func doExportReadingList() error {
+
+	wf.Configure(aw.TextErrors(true))
+
+	log.Printf("format=%s, output=%q, notes=%v", exportFormat, exportOutput, exportNotes)
+
+	bms, err := indexBookmarks(func(bm *indexBookmark) bool { return bm.ReadingList })
+	if err != nil {
+		return err
+	}
+
+	if exportNotes {
+		if exportFormat != formatMarkdown {
+			return errors.New("--notes requires --format markdown")
+		}
+		if exportOutput == "" || exportOutput == "-" {
+			return errors.New("--notes requires an --output directory")
+		}
+		n, err := writeNotes(bms, exportOutput)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Exported %d note(s) to %s\n", n, exportOutput)
+		return nil
+	}
+
+	var w io.Writer = os.Stdout
+	if exportOutput != "" && exportOutput != "-" {
+		f, err := os.Create(exportOutput)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	switch exportFormat {
+	case formatMarkdown:
+		err = writeMarkdown(w, bms)
+	case formatPocket:
+		err = writePocketHTML(w, bms)
+	case formatInstapaper:
+		err = writeInstapaperCSV(w, bms)
+	case formatJSON:
+		err = writeEntriesJSON(w, bms)
+	default:
+		err = fmt.Errorf("Unknown format: %s", exportFormat)
+	}
+	if err != nil {
+		return errors.Wrap(err, "export reading list")
+	}
+	log.Printf("exported %d Reading List entries as %s", len(bms), exportFormat)
+	return nil
+}
+
+// doExportHistory writes history visits to a file (or STDOUT).
+func doExportHistory() error {
+
+	wf.Configure(aw.TextErrors(true))
+
+	log.Printf("format=%s, output=%q, range=%q, host=%q",
+		exportHistoryFormat, exportOutput, exportRange, exportHost)
+
+	dr, err := parseExportRange(exportRange, time.Now())
+	if err != nil {
+		return err
+	}
+	log.Printf("dates=%s", dr)
+
+	visits, err := historyVisits(dr, exportHost)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if exportOutput != "" && exportOutput != "-" {
+		f, err := os.Create(exportOutput)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	switch exportHistoryFormat {
+	case formatCSV:
+		err = writeVisitsCSV(w, visits)
+	case formatJSON, formatNDJSON:
+		err = writeVisitsJSON(w, visits, exportHistoryFormat == formatNDJSON)
+	default:
+		err = fmt.Errorf("Unknown format: %s", exportHistoryFormat)
+	}
+	if err != nil {
+		return errors.Wrap(err, "export history")
+	}
+	log.Printf("exported %d visit(s) as %s", len(visits), exportHistoryFormat)
+	return nil
+}
+
+// parseExportRange parses --range. It accepts the same date syntax as
+// history searches, plus "7d" or "2w" for the last so many days.
+func parseExportRange(s string, now time.Time) (dateRange, error) {
+	text, dr := parseDateQuery(s, now)
+	if text != "" {
+		days, err := parseStatsRange(text)
+		if err != nil {
+			return dateRange{}, err
+		}
+		dr.From = startOfDay(now).AddDate(0, 0, 1-days)
+	}
+	return dr, nil
+}
+
+// entryTitle returns the entry's title or its URL if it has none.
+func entryTitle(bm *indexBookmark) string {
+	if bm.RawTitle != "" {
+		return bm.RawTitle
+	}
+	return bm.URL
+}
+
+// writeMarkdown writes entries as a Markdown task list. Read entries
+// are checked.
+func writeMarkdown(w io.Writer, bms []*indexBookmark) error {
+	if _, err := fmt.Fprintf(w, "# Reading List\n\nExported %s\n\n", time.Now().Format("2006-01-02")); err != nil {
+		return err
+	}
+	for _, bm := range bms {
+		check := " "
+		if bm.Read() {
+			check = "x"
+		}
+		s := fmt.Sprintf("- [%s] [%s](%s)", check, markdownEscape(entryTitle(bm)), bm.URL)
+		if !bm.DateAdded.IsZero() {
+			s += " — added " + bm.DateAdded.Format("2006-01-02")
+		}
+		if bm.Preview != "" {
+			s += "\n    > " + strings.Join(strings.Fields(bm.Preview), " ")
+		}
+		if _, err := fmt.Fprintln(w, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeNotes writes each entry to its own Markdown file with YAML
+// front-matter. Existing notes with the same name are overwritten.
+func writeNotes(bms []*indexBookmark, dir string) (int, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return 0, err
+	}
+
+	// Names already used, case-folded as macOS's filesystems are usually
+	// case-insensitive
+	used := map[string]bool{}
+	for _, bm := range bms {
+		var (
+			base = noteFilename(entryTitle(bm))
+			name = base
+		)
+		for i := 2; used[strings.ToLower(name)]; i++ {
+			name = fmt.Sprintf("%s %d", base, i)
+		}
+		used[strings.ToLower(name)] = true
+
+		var b strings.Builder
+		b.WriteString("---\n")
+		fmt.Fprintf(&b, "title: %s\n", yamlString(entryTitle(bm)))
+		fmt.Fprintf(&b, "url: %s\n", yamlString(bm.URL))
+		if !bm.DateAdded.IsZero() {
+			fmt.Fprintf(&b, "added: %s\n", bm.DateAdded.Format(time.RFC3339))
+		}
+		fmt.Fprintf(&b, "read: %v\n", bm.Read())
+		if bm.Read() {
+			fmt.Fprintf(&b, "read_at: %s\n", bm.DateLastViewed.Format(time.RFC3339))
+		}
+		b.WriteString("source: Safari Reading List\n---\n\n")
+		fmt.Fprintf(&b, "# %s\n\n<%s>\n", entryTitle(bm), bm.URL)
+		if bm.Preview != "" {
+			fmt.Fprintf(&b, "\n> %s\n", strings.Join(strings.Fields(bm.Preview), " "))
+		}
+
+		path := filepath.Join(dir, name+".md")
+		if err := ioutil.WriteFile(path, []byte(b.String()), 0600); err != nil {
+			return 0, err
+		}
+	}
+	return len(bms), nil
+}
+
+// writePocketHTML writes entries in the format of Pocket's HTML export,
+// which Pocket (and other services) can import.
+func writePocketHTML(w io.Writer, bms []*indexBookmark) error {
+	var b strings.Builder
+	b.WriteString(`<!DOCTYPE html>
+<html>
+<head>
+<meta http-equiv="Content-Type" content="text/html; charset=UTF-8">
+<title>Pocket Export</title>
+</head>
+<body>
+`)
+	for _, read := range []bool{false, true} {
+		if read {
+			b.WriteString("<h1>Read Archive</h1>\n<ul>\n")
+		} else {
+			b.WriteString("<h1>Unread</h1>\n<ul>\n")
+		}
+		for _, bm := range bms {
+			if bm.Read() != read {
+				continue
+			}
+			fmt.Fprintf(&b, "<li><a href=\"%s\" time_added=\"%d\" tags=\"%s\">%s</a></li>\n",
+				html.EscapeString(bm.URL), unixTime(bm.DateAdded),
+				html.EscapeString(strings.Join(bm.Tags, ",")), html.EscapeString(entryTitle(bm)))
+		}
+		b.WriteString("</ul>\n")
+	}
+	b.WriteString("</body>\n</html>\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeInstapaperCSV writes entries in the format of Instapaper's CSV
+// export. Read entries go in the Archive folder.
+func writeInstapaperCSV(w io.Writer, bms []*indexBookmark) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"URL", "Title", "Selection", "Folder", "Timestamp"}); err != nil {
+		return err
+	}
+	for _, bm := range bms {
+		folder := "Unread"
+		if bm.Read() {
+			folder = "Archive"
+		}
+		rec := []string{bm.URL, entryTitle(bm), bm.Preview, folder, fmt.Sprintf("%d", unixTime(bm.DateAdded))}
+		if err := cw.Write(rec); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeEntriesJSON writes entries as a JSON array.
+func writeEntriesJSON(w io.Writer, bms []*indexBookmark) error {
+	entries := make([]exportEntry, len(bms))
+	for i, bm := range bms {
+		entries[i] = exportEntry{
+			Title:     entryTitle(bm),
+			URL:       bm.URL,
+			DateAdded: bm.DateAdded,
+			Read:      bm.Read(),
+			Preview:   bm.Preview,
+		}
+		if bm.Read() {
+			t := bm.DateLastViewed
+			entries[i].DateLastViewed = &t
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
+
+// writeVisitsCSV writes visits as CSV with a header row.
+func writeVisitsCSV(w io.Writer, visits []*historyVisit) error {
+	cw := csv.NewWriter(w)
+	hdr := []string{"Title", "URL", "Host", "Visit Time", "Visit Count", "Redirect From", "Redirect To"}
+	if err := cw.Write(hdr); err != nil {
+		return err
+	}
+	for _, hv := range visits {
+		rec := []string{
+			hv.Title, hv.URL, hv.Host(), hv.Time.Format(time.RFC3339),
+			fmt.Sprintf("%d", hv.VisitCount), hv.RedirectFrom, hv.RedirectTo,
+		}
+		if err := cw.Write(rec); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeVisitsJSON writes visits as a JSON array or, if lines is true,
+// as newline-delimited JSON (one object per line).
+func writeVisitsJSON(w io.Writer, visits []*historyVisit, lines bool) error {
+	out := make([]exportVisit, len(visits))
+	for i, hv := range visits {
+		out[i] = exportVisit{
+			Title:        hv.Title,
+			URL:          hv.URL,
+			Host:         hv.Host(),
+			VisitTime:    hv.Time,
+			VisitCount:   hv.VisitCount,
+			RedirectFrom: hv.RedirectFrom,
+			RedirectTo:   hv.RedirectTo,
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	if !lines {
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	}
+	for _, v := range out {
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// noteFilename returns a filename (without extension) for a note titled s.
+func noteFilename(s string) string {
+	s = strings.Join(strings.Fields(badFilenameRx.ReplaceAllString(s, " ")), " ")
+	s = strings.Trim(s, ". ")
+	if r := []rune(s); len(r) > 100 {
+		s = strings.TrimSpace(string(r[:100]))
+	}
+	if s == "" {
+		s = "Untitled"
+	}
+	return s
+}
+
+// markdownEscape escapes characters that would break a Markdown link text.
+func markdownEscape(s string) string {
+	return strings.NewReplacer(`[`, `\[`, `]`, `\]`).Replace(s)
+}
+
+// yamlString returns s as a double-quoted YAML string.
+func yamlString(s string) string {
+	data, _ := json.Marshal(s) // JSON strings are valid YAML
+	return string(data)
+}
+
+// unixTime returns t as a UNIX timestamp, or 0 if t is zero.
+func unixTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
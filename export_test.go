@@ -0,0 +1,48 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseExportRange(t *testing.T) {
+	var (
+		now = time.Date(2019, 10, 16, 15, 4, 5, 0, time.UTC) // a Wednesday
+		day = func(d int) time.Time { return time.Date(2019, 10, d, 0, 0, 0, 0, time.UTC) }
+	)
+
+	tests := []struct {
+		in       string
+		from, to time.Time
+		start    string
+		ok       bool
+	}{
+		{"", time.Time{}, time.Time{}, "", true},
+		{"7d", day(10), time.Time{}, "", true},
+		{"1d", day(16), time.Time{}, "", true},
+		{"2w", day(3), time.Time{}, "", true},
+		{"today", day(16), time.Time{}, "", true},
+		{"yesterday", day(15), day(16), "", true},
+		{"this week", day(14), time.Time{}, "", true},
+		{"since:2019-10-01 before:mon", day(1), day(14), "", true},
+		{"7d @9am-5pm", day(10), time.Time{}, "09:00", true},
+		{"0d", time.Time{}, time.Time{}, "", false},
+		{"7d 2w", time.Time{}, time.Time{}, "", false},
+		{"fortnight", time.Time{}, time.Time{}, "", false},
+	}
+
+	for _, td := range tests {
+		dr, err := parseExportRange(td.in, now)
+		if (err == nil) != td.ok {
+			t.Errorf("Bad error for %q. Expected ok=%v, Got=%v", td.in, td.ok, err)
+			continue
+		}
+		if !dr.From.Equal(td.from) || !dr.To.Equal(td.to) || dr.Start != td.start {
+			t.Errorf("Bad range for %q. Expected=%v–%v %q, Got=%v–%v %q",
+				td.in, td.from, td.to, td.start, dr.From, dr.To, dr.Start)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	aw "github.com/deanishe/awgo"
+)
+
+// Matches a positional query, e.g. "3" or "3/2/", followed by optional text.
+// A position not ending in "/" must be followed by a space, so "2fa" and
+// "3d printing" are text.
+var positionRx = regexp.MustCompile(`^((?:\d+/)*(?:\d+/|\d+(?:\s+|$)))(.*)$`)
+
+// doFilterFavorites lists the contents of the Favorites bar in the order
+// Safari shows them. Items are numbered, so a query of "3" shows the
+// third favourite and "3/2" the second item in the third favourite (a
+// folder).
+func doFilterFavorites() error {
+
+	showUpdateStatus()
+
+	// Keep Alfred from re-ordering the items based on usage
+	wf.Configure(aw.SuppressUIDs(true))
+
+	idx, err := loadIndex()
+	if err != nil {
+		return err
+	}
+	var bar *indexFolder
+	for _, f := range idx.Folders {
+		if len(f.Path) == 0 && f.Title == topLevelNames[bookmarksBarName] {
+			bar = f
+			break
+		}
+	}
+	if bar == nil {
+		return fmt.Errorf("no such folder: %s", bookmarksBarName)
+	}
+
+	positions, drill, q := parsePositionQuery(query)
+	log.Printf("query=%q, positions=%v", q, positions)
+
+	var (
+		nodes  = favoriteNodes(idx, bar.UID)
+		path   = []string{bar.Title}
+		prefix string
+	)
+
+	for i, pos := range positions {
+		if pos < 1 || pos > len(nodes) {
+			wf.NewWarningItem(fmt.Sprintf("No Favorite %s%d", prefix, pos),
+				fmt.Sprintf("There are %d items in \"%s\"", len(nodes), path[len(path)-1]))
+			wf.SendFeedback()
+			return nil
+		}
+
+		var (
+			n     = nodes[pos-1]
+			label = fmt.Sprintf("%s%d", prefix, pos)
+			last  = i == len(positions)-1
+		)
+
+		if n.Folder == nil {
+			if !last || drill {
+				wf.NewWarningItem(fmt.Sprintf("Favorite %s is not a folder", label), n.Bookmark.Title)
+				wf.SendFeedback()
+				return nil
+			}
+			// Only show selected bookmark
+			favoriteItem(n, label)
+			nodes = nil
+			break
+		}
+
+		// Show selected folder, so it can be opened, followed by its contents
+		if last {
+			favoriteItem(n, label)
+		}
+		nodes = favoriteNodes(idx, n.Folder.UID)
+		path = append(path, n.Folder.Title)
+		prefix = label + "/"
+	}
+
+	for i, n := range nodes {
+		favoriteItem(n, fmt.Sprintf("%s%d", prefix, i+1))
+	}
+
+	if q != "" {
+		res := wf.Filter(q)
+		log.Printf("%d favorite(s) for %q", len(res), q)
+	}
+
+	wf.WarnEmpty("No favorites found", "Try a different query?")
+	wf.SendFeedback()
+	return nil
+}
+
+// parsePositionQuery splits a query into the positions of favourites and
+// the remaining text. drill is true if the positions end with "/", i.e.
+// the query is for the contents of the last folder.
+func parsePositionQuery(query string) (positions []int, drill bool, text string) {
+	m := positionRx.FindStringSubmatch(query)
+	if m == nil {
+		return nil, false, query
+	}
+	pos := strings.TrimSpace(m[1])
+	for _, s := range splitFolderPath(pos) {
+		n, _ := strconv.Atoi(s)
+		positions = append(positions, n)
+	}
+	return positions, strings.HasSuffix(pos, "/"), strings.TrimSpace(m[2])
+}
+
+// favorite is a bookmark or folder in the Favorites bar.
+type favorite struct {
+	Folder   *indexFolder   // nil if item is a bookmark
+	Bookmark *indexBookmark // nil if item is a folder
+	Pos      int
+}
+
+// favoriteNodes returns the bookmarks and folders in the folder with
+// the given UID in Safari's order.
+func favoriteNodes(idx *bookmarkIndex, uid string) []favorite {
+	var (
+		nodes   []favorite
+		fs, bms = idx.Contents(uid)
+	)
+	for _, f := range fs {
+		nodes = append(nodes, favorite{Folder: f, Pos: f.Pos})
+	}
+	for _, bm := range bms {
+		nodes = append(nodes, favorite{Bookmark: bm, Pos: bm.Pos})
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Pos < nodes[j].Pos })
+	return nodes
+}
+
+// favoriteItem returns a numbered feedback Item for a bookmark or folder
+// in the Favorites bar.
+func favoriteItem(n favorite, pos string) *aw.Item {
+
+	if f := n.Folder; f != nil {
+		return folderItem(f).
+			Title(fmt.Sprintf("%s. %s", pos, folderTitle(f))).
+			Match(f.Title).
+			Autocomplete(pos + "/")
+	}
+
+	bm := n.Bookmark
+	return bookmarkItem(bm).
+		Title(fmt.Sprintf("%s. %s", pos, bm.Title)).
+		Match(bm.Title).
+		Autocomplete(pos)
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePositionQuery(t *testing.T) {
+	tests := []struct {
+		in        string
+		positions []int
+		drill     bool
+		text      string
+	}{
+		{"", nil, false, ""},
+		{"go", nil, false, "go"},
+		{"3", []int{3}, false, ""},
+		{"3 ", []int{3}, false, ""},
+		{"3 go", []int{3}, false, "go"},
+		{"3/", []int{3}, true, ""},
+		{"3/go", []int{3}, true, "go"},
+		{"3/ go", []int{3}, true, "go"},
+		{"3/2", []int{3, 2}, false, ""},
+		{"3/2/", []int{3, 2}, true, ""},
+		{"12/1 docs", []int{12, 1}, false, "docs"},
+		// Text starting with a number isn't a position
+		{"2fa", nil, false, "2fa"},
+		{"3d printing", nil, false, "3d printing"},
+		{"3/2fa", []int{3}, true, "2fa"},
+	}
+
+	for _, td := range tests {
+		positions, drill, text := parsePositionQuery(td.in)
+		if !reflect.DeepEqual(positions, td.positions) {
+			t.Errorf("Bad positions for %q. Expected=%v, Got=%v", td.in, td.positions, positions)
+		}
+		if drill != td.drill {
+			t.Errorf("Bad drill for %q. Expected=%v, Got=%v", td.in, td.drill, drill)
+		}
+		if text != td.text {
+			t.Errorf("Bad text for %q. Expected=%q, Got=%q", td.in, td.text, text)
+		}
+	}
+}
@@ -24,17 +24,53 @@ func doFilterAllFolders() error {
 
 	log.Printf("query=%s", query)
 
-	sf := safari.Folders()
+	idx, err := loadIndex()
+	if err != nil {
+		return err
+	}
+
+	// A query containing "/" is a path. The last segment is matched
+	// against folder titles and the others against their parents.
+	var (
+		q       = query
+		parents []string
+	)
+	if strings.Contains(query, "/") {
+		segs := strings.Split(query, "/")
+		q = strings.TrimSpace(segs[len(segs)-1])
+		for _, s := range segs[:len(segs)-1] {
+			if s = strings.TrimSpace(s); s != "" {
+				parents = append(parents, s)
+			}
+		}
+		log.Printf("parents=%v, query=%q", parents, q)
+	}
+
+	// Smart folders go first. They have no parents, so only
+	// show them for non-path queries.
+	if len(parents) == 0 {
+		folders, err := loadSmartFolders()
+		if err != nil {
+			return err
+		}
+		for _, sf := range folders {
+			smartFolderItem(sf)
+		}
+	}
 
 	// Send results
 	// log.Printf("Sending %d results to Alfred ...", len(ff))
-	for _, f := range sf {
-		folderItem(f)
+	for _, f := range idx.Folders {
+		if !matchAncestors(f.Path, parents) {
+			continue
+		}
+		// ⇥ completes folder's path, so the next query shows its subfolders
+		folderItem(f).Autocomplete(strings.Join(f.FullPath(), "/") + "/")
 	}
 
-	if query != "" {
-		res := wf.Filter(query)
-		log.Printf("%d folder(s) match %q", len(res), query)
+	if q != "" {
+		res := wf.Filter(q)
+		log.Printf("%d folder(s) match %q", len(res), q)
 		for i, r := range res {
 			log.Printf("#%02d %5.2f %q", i+1, r.Score, r.SortKey)
 		}
@@ -50,15 +86,24 @@ func doFilterFolder() error {
 
 	log.Printf("query=%s, uid=%s", query, uid)
 
+	if strings.HasPrefix(uid, smartFolderPrefix) {
+		return doFilterSmartFolder()
+	}
+
 	// ----------------------------------------------------------------
 	// Gather results
 
-	f := safari.FolderForUID(uid)
+	idx, err := loadIndex()
+	if err != nil {
+		return err
+	}
+	f := idx.Folder(uid)
 	if f == nil {
 		return fmt.Errorf("No folder found with UID: %s", uid)
 	}
+	folders, bms := idx.Contents(uid)
 
-	log.Printf("%d folders, %d bookmarks in \"%s\"", len(f.Folders), len(f.Bookmarks), f.Title())
+	log.Printf("%d folders, %d bookmarks in \"%s\"", len(folders), len(bms), f.Title)
 
 	// ----------------------------------------------------------------
 	// Show "Back" options if query is empty
@@ -66,51 +111,72 @@ func doFilterFolder() error {
 
 		wf.Configure(aw.SuppressUIDs(true))
 
-		if len(f.Ancestors) > 0 {
+		if ancestors := idx.Ancestors(f); len(ancestors) > 0 {
 
-			p := f.Ancestors[len(f.Ancestors)-1]
+			// Breadcrumb: one item for each ancestor, nearest first
+			for i := len(ancestors) - 1; i >= 0; i-- {
+				p := ancestors[i]
 
-			it := wf.NewItem(fmt.Sprintf("Up to \"%s\"", p.Title())).
-				Icon(IconUp).
-				Valid(true).
-				Var("ALSF_UID", p.UID())
+				it := wf.NewItem(fmt.Sprintf("Up to \"%s\"", p.Title)).
+					Subtitle(strings.Join(p.FullPath(), " / ")).
+					Icon(IconUp).
+					Valid(true).
+					Var("ALSF_UID", p.UID)
 
-			// Alternate action: Go to All Folders
-			it.NewModifier("cmd").
-				Subtitle("Go back to All Folders").
-				Var("action", "top")
+				// Alternate action: Go to All Folders
+				it.NewModifier("cmd").
+					Subtitle("Go back to All Folders").
+					Var("action", "top")
 
-			// Default only
-			it.Var("action", "browse")
-			// it.SetVar("browse_folder", "1")
+				// Default only
+				it.Var("action", "browse")
+				// it.SetVar("browse_folder", "1")
+			}
 		} else if uid != "" { // One of the top-level items, e.g. Favorites
 			wf.NewItem("Back to All Folders").
 				Valid(true).
 				Icon(IconHome).
 				Var("action", "top")
 		}
+
+		// Confirmation for folders with more bookmarks than the limit
+		n := f.NumOpen
+		if openRecursive {
+			n = f.NumOpenAll
+		}
+		if n > maxOpen {
+			it := wf.NewItem(fmt.Sprintf("Open All %d Bookmarks?", n)).
+				Subtitle(fmt.Sprintf("\"%s\" contains more than %d bookmarks", f.Title, maxOpen)).
+				Icon(IconWarning).
+				Valid(true).
+				Var("ALSF_UID", f.UID).
+				Var("ALSF_ACTION", urlActionDefault).
+				Var("ALSF_FORCE", "1").
+				Var("action", "open")
+
+			it.NewModifier("ctrl").
+				Subtitle(fmt.Sprintf("Open all %d bookmarks in New Window", n)).
+				Var("ALSF_NEW_WINDOW", "1").
+				Var("action", "open")
+		}
 	}
 
 	// ----------------------------------------------------------------
-	// Sort Folders and Bookmarks
-	items := []safari.Item{}
+	// Folders, then bookmarks
+	log.Printf("%d item(s) in folder %q", len(folders)+len(bms), f.Title)
 
-	for _, f2 := range f.Folders {
-		items = append(items, f2)
-	}
-	for _, bm := range f.Bookmarks {
-		items = append(items, bm)
+	if bmSort != "" {
+		loadVisits(bms)
+		bms = sortByVisits(bms, bmSort)
+		wf.Configure(aw.SuppressUIDs(true))
 	}
-	log.Printf("%d item(s) in folder %q", len(items), f.Title())
 
-	for _, it := range items {
-		if bm, ok := it.(*safari.Bookmark); ok {
-			URLerItem(&bmURLer{bm})
-		} else if f2, ok := it.(*safari.Folder); ok {
-			folderItem(f2)
-		} else {
-			log.Printf("Could't cast item: %v", it)
-		}
+	for _, f2 := range folders {
+		folderItem(f2)
+	}
+	order := map[*aw.Item]int{}
+	for i, bm := range bms {
+		order[bookmarkItem(bm)] = i
 	}
 	if query != "" {
 		res := wf.Filter(query)
@@ -118,6 +184,9 @@ func doFilterFolder() error {
 		for i, r := range res {
 			log.Printf("#%02d %5.2f %q", i+1, r.Score, r.SortKey)
 		}
+		if bmSort != "" {
+			restoreOrder(order)
+		}
 	}
 
 	wf.WarnEmpty("No bookmarks or folders found", "Try a different query?")
@@ -130,44 +199,108 @@ func doFilterFolder() error {
 // Helpers
 
 // folderSubtitle generates a subtitle for a Folder.
-func folderSubtitle(f *safari.Folder) string {
+func folderSubtitle(f *indexFolder) string {
+	return strings.Join(f.Path, " / ")
+}
+
+// folderPath returns the titles of a Folder's ancestors and the Folder itself.
+func folderPath(f *safari.Folder) []string {
 	s := []string{}
 	for _, f2 := range f.Ancestors {
 		s = append(s, f2.Title())
 	}
-	return strings.Join(s, " / ")
+	return append(s, f.Title())
+}
+
+// matchAncestors returns true if names fuzzy-match the end of path,
+// i.e. the last name must match a folder's parent, the one before
+// that its grandparent etc.
+func matchAncestors(path, names []string) bool {
+	if len(names) > len(path) {
+		return false
+	}
+	off := len(path) - len(names)
+	for i, name := range names {
+		if !fuzzyMatch(path[off+i], name) {
+			return false
+		}
+	}
+	return true
+}
+
+// fuzzyMatch returns true if all characters of query appear in s in order.
+// Case is ignored.
+func fuzzyMatch(s, query string) bool {
+	r := []rune(strings.ToLower(query))
+	if len(r) == 0 {
+		return true
+	}
+	for _, c := range strings.ToLower(s) {
+		if c == r[0] {
+			if r = r[1:]; len(r) == 0 {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // folderTitle generates a title for a Folder.
-func folderTitle(f *safari.Folder) string {
-	return fmt.Sprintf("%s (%d bookmarks)", f.Title(), len(f.Bookmarks))
+func folderTitle(f *indexFolder) string {
+	return fmt.Sprintf("%s (%d bookmarks)", f.Title, f.NumBookmarks)
 }
 
 // folderItem returns a feedback Item for Safari Folder.
-func folderItem(f *safari.Folder) *aw.Item {
+func folderItem(f *indexFolder) *aw.Item {
 
 	it := wf.NewItem(folderTitle(f)).
 		Subtitle(folderSubtitle(f)).
-		Match(f.Title()).
-		UID(f.UID()).
+		Match(f.Title).
+		UID(f.UID).
 		Icon(IconFolder)
 
 	// Make folder actionable if it isn't empty
-	if len(f.Bookmarks)+len(f.Folders) > 0 {
+	if f.NumBookmarks+f.NumFolders > 0 {
+		// Search bookmarks in folder and its subfolders
+		q := &searchQuery{Folders: []string{strings.Join(f.FullPath(), "/")}}
+		it.NewModifier("alt").
+			Subtitle(fmt.Sprintf("Search within \"%s\"", f.Title)).
+			Arg(q.String()+" ").
+			Icon(IconBookmark).
+			Var("action", "search-in")
+
 		it.Valid(true).
-			Var("ALSF_UID", f.UID()).
+			Var("ALSF_UID", f.UID).
 			Var("ALSF_ACTION", urlActionDefault)
 
 		// Allow opening folder if it contains bookmarks
-		m := it.NewModifier("cmd")
+		var (
+			n   = f.NumOpen
+			m   = it.NewModifier("cmd")
+			mNW = it.NewModifier("ctrl")
+		)
+		if openRecursive {
+			n = f.NumOpenAll
+		}
 
-		if len(f.Bookmarks) > 0 {
+		if n > 0 {
 
-			m.Subtitle(fmt.Sprintf("Open %d bookmark(s)", len(f.Bookmarks)))
+			m.Subtitle(fmt.Sprintf("Open %d bookmark(s)", n))
 			m.Var("action", "open")
+			mNW.Subtitle(fmt.Sprintf("Open %d bookmark(s) in New Window", n))
+			mNW.Var("action", "open").Var("ALSF_NEW_WINDOW", "1")
+
+			// Too many bookmarks: browse folder to get confirmation item
+			if n > maxOpen {
+				m.Subtitle(fmt.Sprintf("Open %d bookmark(s)? (more than %d)", n, maxOpen))
+				m.Var("action", "browse")
+				mNW.Subtitle(fmt.Sprintf("Open %d bookmark(s) in New Window? (more than %d)", n, maxOpen))
+				mNW.Var("action", "browse")
+			}
 
 		} else {
 			m.Valid(false)
+			mNW.Valid(false)
 		}
 		// Default only
 		it.Var("action", "browse")
@@ -0,0 +1,309 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	aw "github.com/deanishe/awgo"
+	"github.com/deanishe/go-safari/history"
+	"github.com/pkg/errors"
+)
+
+// forgetItem is a history item with visits to delete.
+type forgetItem struct {
+	ID          int64
+	URL         string
+	Title       string
+	Visits      []int64
+	First, Last float64 // Core Data times of oldest & newest visits
+}
+
+// doForgetHistory deletes history visits matching a URL, host, query
+// and/or range. Without --apply, the visits that would be deleted are
+// shown in Alfred with a confirmation item.
+func doForgetHistory() error {
+
+	log.Printf("url=%q, host=%q, query=%q, range=%q, apply=%v",
+		forgetURL, forgetHost, query, forgetRange, forgetApply)
+
+	if forgetApply {
+		wf.Configure(aw.TextErrors(true))
+	}
+
+	if forgetURL == "" && forgetHost == "" && query == "" && forgetRange == "" {
+		return errors.New("Specify a URL, host, query or range")
+	}
+
+	var dr dateRange
+	if forgetRange != "" {
+		days, err := parseStatsRange(forgetRange)
+		if err != nil {
+			return err
+		}
+		dr.From = startOfDay(time.Now()).AddDate(0, 0, 1-days)
+	}
+
+	items, err := forgetItems(forgetURL, forgetHost, query, dr)
+	if err != nil {
+		return err
+	}
+	n := 0
+	for _, it := range items {
+		n += len(it.Visits)
+	}
+	log.Printf("%d visit(s) to %d page(s) to forget", n, len(items))
+
+	if !forgetApply {
+		return previewForget(items, n)
+	}
+
+	if isLiveHistory(historyDB) && safariRunning() {
+		return errors.New("Quit Safari before deleting history")
+	}
+	if len(items) == 0 {
+		fmt.Println("Nothing to delete")
+		return nil
+	}
+	if err := backupHistory(historyDB); err != nil {
+		return errors.Wrap(err, "backup history")
+	}
+	if err := deleteVisits(items); err != nil {
+		return err
+	}
+	resetHistoryIndex()
+	fmt.Printf("Deleted %s to %s from History\n", plural(n, "visit"), plural(len(items), "page"))
+	return nil
+}
+
+// previewForget shows the pages forget would delete visits to.
+func previewForget(items []*forgetItem, n int) error {
+
+	wf.Configure(aw.SuppressUIDs(true))
+
+	if len(items) > 0 {
+		it := wf.NewItem(fmt.Sprintf("Delete %s to %s from History?", plural(n, "visit"), plural(len(items), "page"))).
+			Subtitle("History.db will be backed up first").
+			Icon(IconWarning).
+			Valid(true).
+			Var("ALSF_URL", forgetURL).
+			Var("ALSF_HOST", forgetHost).
+			Var("ALSF_RANGE", forgetRange).
+			Var("ALSF_QUERY", query).
+			Var("action", "forget-apply")
+
+		if isLiveHistory(historyDB) && safariRunning() {
+			it.Subtitle("Quit Safari first").Valid(false)
+		}
+	}
+
+	for _, it := range items {
+		e := &history.Entry{URL: it.URL, Title: it.Title}
+		URLerItem(&hURLer{e}).
+			Subtitle(fmt.Sprintf("%s · %s", plural(len(it.Visits), "visit"), it.URL))
+	}
+
+	wf.WarnEmpty("No matching history found", "Try a different URL, host or range?")
+	wf.SendFeedback()
+	return nil
+}
+
+// forgetItems returns history items and their visits that match URL,
+// host, query text and date range. Empty values match everything.
+func forgetItems(URL, host, text string, dr dateRange) ([]*forgetItem, error) {
+
+	conds, args := dateConds(dr)
+	if URL != "" {
+		conds = append(conds, "i.url = ?")
+		args = append(args, URL)
+	}
+	if host != "" {
+		conds = append(conds, "i.url LIKE ?")
+		args = append(args, "%"+host+"%")
+	}
+
+	q := `
+		SELECT i.id, i.url, v.id, IFNULL(v.title, ''), v.visit_time
+		FROM history_visits v JOIN history_items i ON v.history_item = i.id`
+	if len(conds) > 0 {
+		q += "\n\t\tWHERE " + strings.Join(conds, " AND ")
+	}
+	q += "\n\t\tORDER BY v.visit_time DESC"
+
+	db, err := openSQLite(historyDB, true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var (
+		sq    = &searchQuery{Text: text}
+		byID  = map[int64]*forgetItem{}
+		items []*forgetItem
+	)
+	if host != "" {
+		sq.Hosts = []string{strings.ToLower(host)}
+	}
+	for rows.Next() {
+		var (
+			itemID, visitID int64
+			URL, title      string
+			ts              float64
+		)
+		if err := rows.Scan(&itemID, &URL, &visitID, &title, &ts); err != nil {
+			return nil, err
+		}
+		if !sq.matchHost(URL) || !sq.matchText(title, URL) {
+			continue
+		}
+		it, ok := byID[itemID]
+		if !ok {
+			it = &forgetItem{ID: itemID, URL: URL, Title: title, Last: ts} // newest title
+			byID[itemID] = it
+			items = append(items, it)
+		}
+		it.Visits = append(it.Visits, visitID)
+		it.First = ts
+	}
+	return items, rows.Err()
+}
+
+// deleteVisits deletes visits from the history database. Items left
+// without visits are deleted, too.
+func deleteVisits(items []*forgetItem) error {
+
+	db, err := openSQLite(historyDB, false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := deleteVisitsTx(tx, items); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// deleteVisitsTx deletes visits and emptied items within transaction tx.
+func deleteVisitsTx(tx *sql.Tx, items []*forgetItem) error {
+
+	var ids []interface{}
+	for _, it := range items {
+		for _, id := range it.Visits {
+			ids = append(ids, id)
+		}
+	}
+
+	for i := 0; i < len(ids); i += maxSQLParams {
+		batch := ids[i:]
+		if len(batch) > maxSQLParams {
+			batch = batch[:maxSQLParams]
+		}
+		in := "(?" + strings.Repeat(",?", len(batch)-1) + ")"
+		for _, q := range []string{
+			// Unlink redirects to and from the deleted visits
+			"UPDATE history_visits SET redirect_source = NULL WHERE redirect_source IN " + in,
+			"UPDATE history_visits SET redirect_destination = NULL WHERE redirect_destination IN " + in,
+			"DELETE FROM history_visits WHERE id IN " + in,
+		} {
+			if _, err := tx.Exec(q, batch...); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Record deletions in tombstones, which iCloud syncs to other
+	// devices, so they don't restore the deleted visits. Older
+	// versions of Safari don't have the table.
+	var tombstones int
+	if err := tx.QueryRow(`
+		SELECT COUNT(*) FROM sqlite_master
+		WHERE type = 'table' AND name = 'history_tombstones'`).Scan(&tombstones); err != nil {
+		return err
+	}
+
+	for _, it := range items {
+		if tombstones > 0 {
+			_, err := tx.Exec("INSERT INTO history_tombstones (start_time, end_time, url) VALUES (?, ?, ?)",
+				it.First, it.Last, it.URL)
+			if err != nil {
+				return err
+			}
+		}
+
+		var n int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM history_visits WHERE history_item = ?", it.ID).Scan(&n); err != nil {
+			return err
+		}
+		if n == 0 {
+			_, err := tx.Exec("DELETE FROM history_items WHERE id = ?", it.ID)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := tx.Exec("UPDATE history_items SET visit_count = ? WHERE id = ?", n, it.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forgetModifier adds a ⌘⌥↩ "Delete from History" action to Item,
+// which shows the visits to URL that would be deleted.
+func forgetModifier(it *aw.Item, URL string) {
+	it.NewModifier("cmd", "alt").
+		Subtitle("Delete from History…").
+		Arg("").
+		Valid(true).
+		Icon(IconWarning).
+		Var("ALSF_URL", URL).
+		Var("action", "forget")
+}
+
+// safariRunning returns true if Safari is running.
+func safariRunning() bool {
+	return exec.Command("/usr/bin/pgrep", "-xq", "Safari").Run() == nil
+}
+
+// isLiveHistory returns true if path is Safari's own History.db. Only the
+// path is checked: copies are never live, even if they have a write-ahead
+// log (as a copy made with its -wal file does).
+func isLiveHistory(path string) bool {
+	return isLiveFile(path, defaultHistoryDB)
+}
+
+// backupHistory backs up the history database at path and its
+// write-ahead log and shared-memory files, if they exist.
+func backupHistory(path string) error {
+	if err := backupFile(path); err != nil {
+		return err
+	}
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if _, err := os.Stat(path + suffix); err != nil {
+			continue
+		}
+		if err := backupFile(path + suffix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// withHistoryDB creates a History.db from testdata/History.sql and sets
+// historyDB to it for the duration of fn.
+func withHistoryDB(t testing.TB, fn func(path string)) {
+	t.Helper()
+	schema, err := ioutil.ReadFile(filepath.Join("testdata", "History.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "alsf-history-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "History.db")
+	db, err := openSQLite(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(string(schema)); err != nil {
+		db.Close()
+		t.Fatalf("create History.db: %v", err)
+	}
+	db.Close()
+
+	prev := historyDB
+	historyDB = path
+	defer func() { historyDB = prev }()
+	fn(path)
+}
+
+func TestForgetItems(t *testing.T) {
+	withHistoryDB(t, func(_ string) {
+		tests := []struct {
+			url, host, text string
+			x               []string
+			visits          int
+		}{
+			{"https://golang.org/", "", "", []string{"https://golang.org/"}, 2},
+			{"", "golang.org", "", []string{"https://golang.org/", "https://blog.golang.org/go1.13"}, 3},
+			{"", "example.com", "", []string{"https://example.com/page", "https://www.example.com/"}, 3},
+			{"", "", "released", []string{"https://blog.golang.org/go1.13"}, 1},
+			{"", "example.com", "page", []string{"https://example.com/page"}, 1},
+			{"https://nothere.net/", "", "", nil, 0},
+		}
+		for _, td := range tests {
+			items, err := forgetItems(td.url, td.host, td.text, dateRange{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var (
+				urls []string
+				n    int
+			)
+			for _, it := range items {
+				urls = append(urls, it.URL)
+				n += len(it.Visits)
+			}
+			if !reflect.DeepEqual(urls, td.x) {
+				t.Errorf("url=%q, host=%q, text=%q: Expected=%q, Got=%q", td.url, td.host, td.text, td.x, urls)
+			}
+			if n != td.visits {
+				t.Errorf("url=%q, host=%q, text=%q: Expected %d visits, Got %d", td.url, td.host, td.text, td.visits, n)
+			}
+		}
+	})
+}
+
+func TestDeleteVisits(t *testing.T) {
+	withHistoryDB(t, func(path string) {
+		// Redirect source (t.co) and one of example.com's visits
+		items, err := forgetItems("", "", "", dateRange{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var del []*forgetItem
+		for _, it := range items {
+			switch it.URL {
+			case "https://t.co/abc123":
+				del = append(del, it)
+			case "https://www.example.com/":
+				it.Visits = []int64{6} // keep visit 4
+				it.First, it.Last = 590200001, 590200001
+				del = append(del, it)
+			}
+		}
+		if len(del) != 2 {
+			t.Fatalf("Expected 2 items, Got %d", len(del))
+		}
+		if err := deleteVisits(del); err != nil {
+			t.Fatalf("delete visits: %v", err)
+		}
+
+		db, err := openSQLite(path, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		var n int
+		db.QueryRow("SELECT COUNT(*) FROM history_items WHERE url = 'https://t.co/abc123'").Scan(&n)
+		if n != 0 {
+			t.Errorf("Empty item not deleted")
+		}
+		db.QueryRow("SELECT visit_count FROM history_items WHERE id = 4").Scan(&n)
+		if n != 1 {
+			t.Errorf("Bad visit_count. Expected=1, Got=%d", n)
+		}
+		db.QueryRow("SELECT COUNT(*) FROM history_visits").Scan(&n)
+		if n != 5 {
+			t.Errorf("Bad visits. Expected=5, Got=%d", n)
+		}
+
+		rows, err := db.Query("SELECT url, start_time, end_time FROM history_tombstones ORDER BY url")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		type tombstone struct {
+			URL        string
+			Start, End float64
+		}
+		var ts []tombstone
+		for rows.Next() {
+			var v tombstone
+			if err := rows.Scan(&v.URL, &v.Start, &v.End); err != nil {
+				t.Fatal(err)
+			}
+			ts = append(ts, v)
+		}
+		x := []tombstone{
+			{"https://t.co/abc123", 590200000, 590200000},
+			{"https://www.example.com/", 590200001, 590200001},
+		}
+		if !reflect.DeepEqual(ts, x) {
+			t.Errorf("Bad tombstones. Expected=%v, Got=%v", x, ts)
+		}
+	})
+}
+
+// A host that only appears in another site's URL isn't forgotten.
+func TestForgetHostInPath(t *testing.T) {
+	withHistoryDB(t, func(path string) {
+		db, err := openSQLite(path, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		_, err = db.Exec(`
+			INSERT INTO history_items (id, url, domain_expansion, visit_count) VALUES
+				(6, 'https://other.com/?u=example.com', 'other', 1),
+				(7, 'https://notexample.com/', 'notexample', 1);
+			INSERT INTO history_visits (id, history_item, visit_time, title) VALUES
+				(8, 6, 590400000.0, 'example.com'),
+				(9, 7, 590400001.0, 'Not Example');`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		items, err := forgetItems("", "example.com", "", dateRange{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var urls []string
+		for _, it := range items {
+			urls = append(urls, it.URL)
+		}
+		x := []string{"https://example.com/page", "https://www.example.com/"}
+		if !reflect.DeepEqual(urls, x) {
+			t.Errorf("Bad items for example.com. Expected=%q, Got=%q", x, urls)
+		}
+		if err := deleteVisits(items); err != nil {
+			t.Fatal(err)
+		}
+
+		var n int
+		db.QueryRow("SELECT COUNT(*) FROM history_items WHERE id IN (6, 7)").Scan(&n)
+		if n != 2 {
+			t.Errorf("Bad items. Expected=2, Got=%d", n)
+		}
+		db.QueryRow("SELECT COUNT(*) FROM history_visits WHERE id IN (8, 9)").Scan(&n)
+		if n != 2 {
+			t.Errorf("Bad visits. Expected=2, Got=%d", n)
+		}
+		db.QueryRow("SELECT COUNT(*) FROM history_tombstones WHERE url NOT LIKE '%//%example.com/%'").Scan(&n)
+		if n != 0 {
+			t.Errorf("Bad tombstones. Expected=0, Got=%d", n)
+		}
+	})
+}
+
+func TestDeleteVisitsNoTombstones(t *testing.T) {
+	withHistoryDB(t, func(path string) {
+		db, err := openSQLite(path, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec("DROP TABLE history_tombstones"); err != nil {
+			t.Fatal(err)
+		}
+		db.Close()
+
+		items, err := forgetItems("https://golang.org/", "", "", dateRange{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := deleteVisits(items); err != nil {
+			t.Fatalf("delete visits without tombstones: %v", err)
+		}
+	})
+}
+
+func TestIsLiveHistory(t *testing.T) {
+	withHistoryDB(t, func(path string) {
+		if isLiveHistory(path) {
+			t.Errorf("Copy of History.db is live")
+		}
+		// A copy is not live, even with a write-ahead log
+		if err := ioutil.WriteFile(path+"-wal", nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if isLiveHistory(path) {
+			t.Errorf("Copy of History.db with WAL is live")
+		}
+	})
+}
+
+func TestBackupHistory(t *testing.T) {
+	withHistoryDB(t, func(path string) {
+		for _, suffix := range []string{"-wal", "-shm"} {
+			if err := ioutil.WriteFile(path+suffix, []byte(suffix), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		dir := filepath.Join(wf.DataDir(), "backups")
+		os.RemoveAll(dir)
+		if err := backupHistory(path); err != nil {
+			t.Fatal(err)
+		}
+		for _, glob := range []string{"History-*.db", "History-*.db-wal", "History-*.db-shm"} {
+			if m, _ := filepath.Glob(filepath.Join(dir, glob)); len(m) != 1 {
+				t.Errorf("Expected 1 %s backup, Got %d", glob, len(m))
+			}
+		}
+	})
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	aw "github.com/deanishe/awgo"
+)
+
+// frecencyScores returns the frecency of URLs: the sum of their visits,
+// each weighted by its age, so that a visit halfLife days ago counts half
+// as much as one today. URLs never visited are not in the returned map.
+func frecencyScores(urls []string, halfLife float64, now time.Time) (map[string]float64, error) {
+
+	start := time.Now()
+	db, err := openSQLite(historyDB, true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	scores := map[string]float64{}
+	for i := 0; i < len(urls); i += maxSQLParams {
+		batch := urls[i:]
+		if len(batch) > maxSQLParams {
+			batch = batch[:maxSQLParams]
+		}
+
+		args := make([]interface{}, len(batch))
+		for j, u := range batch {
+			args[j] = u
+		}
+		q := `
+			SELECT i.url, v.visit_time
+			FROM history_visits v JOIN history_items i ON v.history_item = i.id
+			WHERE i.url IN (?` + strings.Repeat(",?", len(batch)-1) + `)`
+
+		rows, err := db.Query(q, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var (
+				URL string
+				ts  float64
+			)
+			if err := rows.Scan(&URL, &ts); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			age := now.Sub(fromCoreData(ts)).Hours() / 24
+			if age < 0 {
+				age = 0
+			}
+			scores[URL] += math.Pow(0.5, age/halfLife)
+		}
+		if err := rows.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Printf("[frecency] scored %d/%d URL(s) in %v", len(scores), len(urls), time.Since(start))
+	return scores, nil
+}
+
+// rankByFrecency re-orders the feedback Items by a blend of their text
+// scores and the frecency of their URLs. textScores[i] is the score of
+// wf.Feedback.Items[i] and urls maps Items to their URLs. The text score
+// and frecency are both scaled to 0–1 and combined as
+//
+//	(1 - frecencyWeight) × text + frecencyWeight × frecency
+func rankByFrecency(textScores []float64, urls map[*aw.Item]string) {
+
+	items := wf.Feedback.Items
+	if frecencyWeight <= 0 || frecencyHalfLife <= 0 || len(items) < 2 || len(textScores) != len(items) {
+		return
+	}
+
+	var list []string
+	for _, it := range items {
+		if u, ok := urls[it]; ok {
+			list = append(list, u)
+		}
+	}
+	freq, err := frecencyScores(list, frecencyHalfLife, time.Now())
+	if err != nil {
+		log.Printf("[frecency] couldn't load scores: %v", err)
+		return
+	}
+
+	// Scale text scores to 0–1, and frecency logarithmically, so a
+	// handful of very frequently-visited sites don't swamp the rest
+	minText, maxText := math.Inf(1), math.Inf(-1)
+	for _, s := range textScores {
+		minText = math.Min(minText, s)
+		maxText = math.Max(maxText, s)
+	}
+	maxFreq := 0.0
+	for _, f := range freq {
+		maxFreq = math.Max(maxFreq, math.Log1p(f))
+	}
+
+	blended := make(map[*aw.Item]float64, len(items))
+	for i, it := range items {
+		var text, f float64
+		if maxText > minText {
+			text = (textScores[i] - minText) / (maxText - minText)
+		}
+		if maxFreq > 0 {
+			f = math.Log1p(freq[urls[it]]) / maxFreq
+		}
+		blended[it] = (1-frecencyWeight)*text + frecencyWeight*f
+	}
+
+	sort.SliceStable(items, func(i, j int) bool { return blended[items[i]] > blended[items[j]] })
+	log.Printf("[frecency] re-ranked %d item(s), weight=%0.2f, half-life=%0.1f days",
+		len(items), frecencyWeight, frecencyHalfLife)
+}
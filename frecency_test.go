@@ -0,0 +1,100 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	aw "github.com/deanishe/awgo"
+)
+
+func TestFrecencyScores(t *testing.T) {
+	urls := []string{"https://golang.org/", "https://example.com/page", "https://never.example.com/"}
+
+	tests := []struct {
+		now float64 // Core Data time
+		x   map[string]float64
+	}{
+		{590300000, map[string]float64{
+			// Visits 300,000s and 200,000s before now
+			"https://golang.org/":      math.Pow(0.5, 300000/86400.0) + math.Pow(0.5, 200000/86400.0),
+			"https://example.com/page": 1,
+		}},
+		// Visits after now count as today's
+		{590250000, map[string]float64{
+			"https://golang.org/":      math.Pow(0.5, 250000/86400.0) + math.Pow(0.5, 150000/86400.0),
+			"https://example.com/page": 1,
+		}},
+	}
+
+	withHistoryDB(t, func(_ string) {
+		for _, td := range tests {
+			scores, err := frecencyScores(urls, 1, fromCoreData(td.now))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(scores) != len(td.x) {
+				t.Errorf("Bad scores at %v. Expected=%v, Got=%v", td.now, td.x, scores)
+			}
+			for u, x := range td.x {
+				if v := scores[u]; math.Abs(v-x) > 1e-6 {
+					t.Errorf("Bad score for %q at %v. Expected=%f, Got=%f", u, td.now, x, v)
+				}
+			}
+		}
+	})
+}
+
+func TestRankByFrecency(t *testing.T) {
+	prevWeight, prevHalfLife, prevFeedback := frecencyWeight, frecencyHalfLife, wf.Feedback
+	defer func() { frecencyWeight, frecencyHalfLife, wf.Feedback = prevWeight, prevHalfLife, prevFeedback }()
+	// Visits in the fixture are years old
+	frecencyHalfLife = 3650
+
+	tests := []struct {
+		weight float64
+		urls   []string
+		text   []float64
+		x      []string
+	}{
+		// Equal text scores: most visited first
+		{0.3, []string{"https://never.example.com/", "https://example.com/page", "https://golang.org/"},
+			[]float64{1, 1, 1},
+			[]string{"https://golang.org/", "https://example.com/page", "https://never.example.com/"}},
+		// Good text match beats frecency
+		{0.2, []string{"https://never.example.com/", "https://golang.org/", "https://example.com/page"},
+			[]float64{10, 0, 5},
+			[]string{"https://never.example.com/", "https://example.com/page", "https://golang.org/"}},
+		// Frecency only
+		{1, []string{"https://example.com/page", "https://never.example.com/", "https://golang.org/"},
+			[]float64{10, 5, 0},
+			[]string{"https://golang.org/", "https://example.com/page", "https://never.example.com/"}},
+		// No weight: order unchanged
+		{0, []string{"https://never.example.com/", "https://example.com/page", "https://golang.org/"},
+			[]float64{1, 1, 1},
+			[]string{"https://never.example.com/", "https://example.com/page", "https://golang.org/"}},
+	}
+
+	withHistoryDB(t, func(_ string) {
+		for i, td := range tests {
+			frecencyWeight = td.weight
+			wf.Feedback = &aw.Feedback{}
+			urls := map[*aw.Item]string{}
+			for _, u := range td.urls {
+				urls[wf.NewItem(u)] = u
+			}
+			rankByFrecency(td.text, urls)
+
+			var v []string
+			for _, it := range wf.Feedback.Items {
+				v = append(v, urls[it])
+			}
+			if !reflect.DeepEqual(v, td.x) {
+				t.Errorf("#%d: Bad order. Expected=%q, Got=%q", i, td.x, v)
+			}
+		}
+	})
+}
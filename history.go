@@ -10,8 +10,10 @@ package main
 
 import (
 	"log"
+	"time"
 
 	"github.com/deanishe/awgo"
+	"github.com/deanishe/awgo/fuzzy"
 	"github.com/deanishe/go-safari/history"
 )
 
@@ -23,29 +25,72 @@ func doFilterHistory() error {
 	history.MaxSearchResults = maxResults * 10 // allow for lots of duplicates
 	wf.Configure(aw.MaxResults(maxResults))
 
-	entries, err := history.Search(query)
+	var (
+		entries  []*history.Entry
+		scores   []float64 // text scores from the index
+		text, dr = parseDateQuery(query, time.Now())
+		err      error
+	)
+	if dr.IsZero() {
+		// Fetch extra results for frecency to re-rank
+		if entries, scores, err = searchHistoryIndex(query, maxResults*5); err != nil {
+			if err == errNoFTS5 {
+				log.Printf("[index] WARNING: %v. Falling back to slow search.", err)
+			} else {
+				log.Printf("[index] error: %v", err)
+			}
+			// Not go-safari's history.Search, which ignores --history-db
+			scores = nil
+			entries, err = searchHistory(query, dateRange{}, history.MaxSearchResults)
+		}
+	} else {
+		log.Printf("query=%q, dates=%s", text, dr)
+		entries, err = searchHistory(text, dr, history.MaxSearchResults)
+	}
 	if err != nil {
 		return err
 	}
 
+	// Show the destinations of redirects instead of each hop
+	via := collapseRedirects(entries)
+
 	// Remove duplicates
 	var (
 		seen   = map[string]bool{}
 		unique = []*history.Entry{}
+		kept   []float64
 	)
-	for _, e := range entries {
+	for i, e := range entries {
 		if seen[e.URL] {
 			continue
 		}
 		seen[e.URL] = true
 		unique = append(unique, e)
+		if i < len(scores) {
+			kept = append(kept, scores[i])
+		}
 	}
-	entries = unique
+	entries, scores = unique, kept
 
 	log.Printf("%d results for \"%s\"", len(entries), query)
 
+	urls := map[*aw.Item]string{}
 	for _, e := range entries {
-		URLerItem(&hURLer{e})
+		it := historyItem(e)
+		if v, ok := via[e]; ok {
+			it.Subtitle((&hURLer{e}).Subtitle() + viaHost(v))
+			originalURLModifier(it, v)
+		}
+		urls[it] = e.URL
+	}
+	if text != "" {
+		if len(scores) != len(entries) {
+			scores = nil
+			for _, e := range entries {
+				scores = append(scores, fuzzy.Match(e.Title, text).Score)
+			}
+		}
+		rankByFrecency(scores, urls)
 	}
 
 	wf.WarnEmpty("No matching entries found", "Try a different query?")
@@ -54,12 +99,62 @@ func doFilterHistory() error {
 	return nil
 }
 
+// recentHistory returns the most recent (unique) history entries and
+// caches them for the duration of the session.
+func recentHistory() ([]*history.Entry, error) {
+
+	var entries []*history.Entry
+
+	loadHistory := func() (interface{}, error) {
+
+		var (
+			all, entries []*history.Entry
+			err          error
+			seen         = map[string]bool{}
+		)
+
+		all, err = searchHistory("", dateRange{}, recentHistoryEntries)
+		if err != nil {
+			return nil, err
+		}
+
+		// Filter duplicates
+		for _, e := range all {
+			if seen[e.URL] {
+				continue
+			}
+			entries = append(entries, e)
+			seen[e.URL] = true
+		}
+		log.Printf("removed %d duplicates from History", len(all)-len(entries))
+		return entries, nil
+	}
+
+	if err := wf.Session.LoadOrStoreJSON("history", loadHistory, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// historyItem returns a feedback Item for a history entry.
+func historyItem(e *history.Entry) *aw.Item {
+	it := URLerItem(&hURLer{e})
+	forgetModifier(it, e.URL)
+	trailModifier(it, e)
+	return it
+}
+
 type hURLer struct {
 	e *history.Entry
 }
 
-func (u *hURLer) Title() string     { return u.e.Title }
-func (u *hURLer) Subtitle() string  { return u.e.URL }
+func (u *hURLer) Title() string { return u.e.Title }
+func (u *hURLer) Subtitle() string {
+	if u.e.Time.IsZero() {
+		return u.e.URL
+	}
+	return visitTime(u.e.Time) + " · " + u.e.URL
+}
 func (u *hURLer) URL() string       { return u.e.URL }
 func (u *hURLer) UID() string       { return u.e.URL }
 func (u *hURLer) Copytext() string  { return u.e.URL }
@@ -0,0 +1,620 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	aw "github.com/deanishe/awgo"
+	"github.com/deanishe/go-safari/history"
+)
+
+// Safari stores times as seconds since 2001-01-01 (Core Data epoch).
+const coreDataEpoch = 978307200
+
+// Maximum number of parameters in one SQL query. SQLite's default
+// limit is 999.
+const maxSQLParams = 900
+
+// Sort orders for bookmarks.
+const (
+	sortVisits = "visits" // most visits first
+	sortRecent = "recent" // most recently visited first
+	sortNever  = "never"  // only bookmarks never visited
+)
+
+var (
+	// Path to Safari's history database. Set with --history-db.
+	defaultHistoryDB = filepath.Join(os.Getenv("HOME"), "Library/Safari/History.db")
+	historyDB        = defaultHistoryDB
+
+	// Visit statistics for bookmark URLs, loaded by loadVisits.
+	bmVisits map[string]visitStat
+)
+
+// visitStat is the number of visits to a URL and the time of the last one.
+type visitStat struct {
+	Count     int
+	LastVisit time.Time
+}
+
+// String returns visit stats for use in subtitles.
+func (vs visitStat) String() string {
+	if vs.Count == 0 {
+		return "Never visited"
+	}
+	visits := "1 visit"
+	if vs.Count != 1 {
+		visits = fmt.Sprintf("%d visits", vs.Count)
+	}
+	return fmt.Sprintf("Last visited %s · %s", relativeTime(vs.LastVisit), visits)
+}
+
+// fromCoreData converts a Core Data timestamp to a time.Time.
+func fromCoreData(ts float64) time.Time {
+	if ts == 0 {
+		return time.Time{}
+	}
+	sec := int64(ts)
+	return time.Unix(sec+coreDataEpoch, int64((ts-float64(sec))*1e9))
+}
+
+// toCoreData converts a time.Time to a Core Data timestamp.
+func toCoreData(t time.Time) float64 {
+	return float64(t.UnixNano())/1e9 - coreDataEpoch
+}
+
+// visitStats returns visit statistics for URLs from the history database.
+// URLs match history items with the same normaliseURL, e.g. a bookmark
+// for "https://x.com" includes visits to "http://www.x.com/", and visits
+// to all matching items are added up. Variants of the URLs are looked up
+// in batches of maxSQLParams, i.e. with a single query for all but the
+// largest collections. URLs never visited are not in the returned map.
+func visitStats(urls []string) (map[string]visitStat, error) {
+
+	start := time.Now()
+	db, err := openSQLite(historyDB, true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var (
+		variants []string
+		byURL    = map[string][]string{} // variant -> URLs it matches
+		seen     = map[string]bool{}
+	)
+	for _, URL := range urls {
+		if seen[URL] {
+			continue
+		}
+		seen[URL] = true
+		for _, v := range urlVariants(URL) {
+			if _, ok := byURL[v]; !ok {
+				variants = append(variants, v)
+			}
+			byURL[v] = append(byURL[v], URL)
+		}
+	}
+
+	stats := map[string]visitStat{}
+	for i := 0; i < len(variants); i += maxSQLParams {
+		batch := variants[i:]
+		if len(batch) > maxSQLParams {
+			batch = batch[:maxSQLParams]
+		}
+
+		args := make([]interface{}, len(batch))
+		for j, u := range batch {
+			args[j] = u
+		}
+		q := `
+			SELECT i.url, i.visit_count, IFNULL(MAX(v.visit_time), 0)
+			FROM history_items i LEFT JOIN history_visits v ON v.history_item = i.id
+			WHERE i.url IN (?` + strings.Repeat(",?", len(batch)-1) + `)
+			GROUP BY i.id`
+
+		rows, err := db.Query(q, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var (
+				URL   string
+				count int
+				ts    float64
+			)
+			if err := rows.Scan(&URL, &count, &ts); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			for _, u := range byURL[URL] {
+				vs := stats[u]
+				vs.Count += count
+				if t := fromCoreData(ts); t.After(vs.LastVisit) {
+					vs.LastVisit = t
+				}
+				stats[u] = vs
+			}
+		}
+		if err := rows.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Printf("[history] visit stats for %d/%d URL(s) in %v", len(stats), len(urls), time.Since(start))
+	return stats, nil
+}
+
+// urlVariants returns URL and the variants of it that have the same
+// normaliseURL: HTTP and HTTPS, with and without "www." and with and
+// without a trailing slash.
+func urlVariants(URL string) []string {
+	u, err := url.Parse(URL)
+	if err != nil || !isWebURL(URL) || u.Host == "" {
+		return []string{URL}
+	}
+	var (
+		host     = strings.TrimPrefix(u.Host, "www.")
+		path     = strings.TrimSuffix(u.EscapedPath(), "/")
+		query    string
+		variants = []string{URL}
+	)
+	if u.RawQuery != "" {
+		query = "?" + u.RawQuery
+	}
+	for _, scheme := range []string{"https", "http"} {
+		for _, h := range []string{host, "www." + host} {
+			for _, p := range []string{path + "/", path} {
+				if s := scheme + "://" + h + p + query; s != URL {
+					variants = append(variants, s)
+				}
+			}
+		}
+	}
+	return variants
+}
+
+// loadVisits loads visit statistics for bookmarks and Reading List
+// entries into bmVisits. Failure to read the history database is
+// logged, not returned, as the stats are only decoration.
+func loadVisits(bms []*indexBookmark) {
+	var urls []string
+	for _, bm := range bms {
+		if !bm.Bookmarklet {
+			urls = append(urls, bm.URL)
+		}
+	}
+	if len(urls) == 0 {
+		return
+	}
+	stats, err := visitStats(urls)
+	if err != nil {
+		log.Printf("[history] couldn't load visit stats: %v", err)
+		return
+	}
+	bmVisits = stats
+}
+
+// sortByVisits sorts bookmarks by their visit stats or, for sortNever,
+// removes visited bookmarks. loadVisits must be called first.
+func sortByVisits(bms []*indexBookmark, order string) []*indexBookmark {
+	switch order {
+	case sortVisits:
+		sort.SliceStable(bms, func(i, j int) bool {
+			return bmVisits[bms[i].URL].Count > bmVisits[bms[j].URL].Count
+		})
+	case sortRecent:
+		sort.SliceStable(bms, func(i, j int) bool {
+			return bmVisits[bms[i].URL].LastVisit.After(bmVisits[bms[j].URL].LastVisit)
+		})
+	case sortNever:
+		var never []*indexBookmark
+		for _, bm := range bms {
+			if bmVisits[bm.URL].Count == 0 {
+				never = append(never, bm)
+			}
+		}
+		bms = never
+	}
+	return bms
+}
+
+// restoreOrder puts items back in the order given by order after
+// wf.Filter has sorted them by how well they match the query. Items that
+// aren't in order (e.g. folders) go first.
+func restoreOrder(order map[*aw.Item]int) {
+	items := wf.Feedback.Items
+	sort.SliceStable(items, func(i, j int) bool {
+		a, okA := order[items[i]]
+		b, okB := order[items[j]]
+		if okA != okB {
+			return okB
+		}
+		return a < b
+	})
+}
+
+// searchHistory returns history visits within dr whose title or URL
+// contain all words of text, newest first.
+func searchHistory(text string, dr dateRange, limit int) ([]*history.Entry, error) {
+
+	var (
+		start       = time.Now()
+		conds, args = dateConds(dr)
+	)
+
+	for _, w := range strings.Fields(text) {
+		conds = append(conds, "(v.title LIKE ? OR i.url LIKE ?)")
+		w = "%" + w + "%"
+		args = append(args, w, w)
+	}
+
+	q := `
+		SELECT i.url, IFNULL(v.title, ''), v.visit_time
+		FROM history_visits v JOIN history_items i ON v.history_item = i.id`
+	if len(conds) > 0 {
+		q += "\n\t\tWHERE " + strings.Join(conds, " AND ")
+	}
+	q += "\n\t\tORDER BY v.visit_time DESC LIMIT ?"
+	args = append(args, limit)
+
+	db, err := openSQLite(historyDB, true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*history.Entry
+	for rows.Next() {
+		var (
+			e  = &history.Entry{}
+			ts float64
+		)
+		if err := rows.Scan(&e.URL, &e.Title, &ts); err != nil {
+			return nil, err
+		}
+		e.Time = fromCoreData(ts)
+		entries = append(entries, e)
+	}
+	log.Printf("[history] %d visit(s) for %q (%s) in %v", len(entries), text, dr, time.Since(start))
+	return entries, rows.Err()
+}
+
+// dateConds returns SQL conditions and their arguments that restrict
+// history_visits (aliased as v) to visits within dr.
+func dateConds(dr dateRange) ([]string, []interface{}) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+	if !dr.From.IsZero() {
+		conds = append(conds, "v.visit_time >= ?")
+		args = append(args, toCoreData(dr.From))
+	}
+	if !dr.To.IsZero() {
+		conds = append(conds, "v.visit_time < ?")
+		args = append(args, toCoreData(dr.To))
+	}
+	if dr.Start != "" {
+		hm := fmt.Sprintf("strftime('%%H:%%M', v.visit_time + %d, 'unixepoch', 'localtime')", coreDataEpoch)
+		op := "AND"
+		if dr.End < dr.Start { // wraps around midnight
+			op = "OR"
+		}
+		conds = append(conds, fmt.Sprintf("(%s >= ? %s %s < ?)", hm, op, hm))
+		args = append(args, dr.Start, dr.End)
+	}
+	return conds, args
+}
+
+// pageVisits is the number of visits to a URL and the time of the last one.
+type pageVisits struct {
+	URL       string
+	Title     string // title of most recent visit
+	Count     int
+	LastVisit time.Time
+}
+
+// visitsByURL returns the visits within dr grouped by URL. If like is
+// non-empty, only URLs containing it are returned.
+func visitsByURL(dr dateRange, like string) ([]*pageVisits, error) {
+
+	start := time.Now()
+	conds, args := dateConds(dr)
+	if like != "" {
+		conds = append(conds, "i.url LIKE ?")
+		args = append(args, "%"+like+"%")
+	}
+
+	// SQLite takes bare columns (v.title) from the row with MAX(visit_time)
+	q := `
+		SELECT i.url, IFNULL(v.title, ''), COUNT(v.id), MAX(v.visit_time)
+		FROM history_visits v JOIN history_items i ON v.history_item = i.id`
+	if len(conds) > 0 {
+		q += "\n\t\tWHERE " + strings.Join(conds, " AND ")
+	}
+	q += "\n\t\tGROUP BY i.id"
+
+	db, err := openSQLite(historyDB, true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pages []*pageVisits
+	for rows.Next() {
+		var (
+			p  = &pageVisits{}
+			ts float64
+		)
+		if err := rows.Scan(&p.URL, &p.Title, &p.Count, &ts); err != nil {
+			return nil, err
+		}
+		p.LastVisit = fromCoreData(ts)
+		pages = append(pages, p)
+	}
+	log.Printf("[history] %d URL(s) visited %s in %v", len(pages), dr, time.Since(start))
+	return pages, rows.Err()
+}
+
+// historyVisit is a single visit to a page.
+type historyVisit struct {
+	ID           int64
+	URL          string
+	Title        string
+	Time         time.Time
+	VisitCount   int    // total visits to URL
+	RedirectFrom string // URL this visit was redirected from
+	RedirectTo   string // URL this visit redirected to
+}
+
+// Host returns the hostname of the visited URL.
+func (hv *historyVisit) Host() string {
+	u, err := url.Parse(hv.URL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// SELECT statement for historyVisit rows, without WHERE clause.
+const visitQuery = `
+		SELECT v.id, i.url, IFNULL(v.title, ''), v.visit_time, i.visit_count,
+			IFNULL(si.url, ''), IFNULL(di.url, '')
+		FROM history_visits v
+		JOIN history_items i ON v.history_item = i.id
+		LEFT JOIN history_visits sv ON v.redirect_source = sv.id
+		LEFT JOIN history_items si ON sv.history_item = si.id
+		LEFT JOIN history_visits dv ON v.redirect_destination = dv.id
+		LEFT JOIN history_items di ON dv.history_item = di.id`
+
+// queryVisits runs visitQuery with the given clauses appended.
+func queryVisits(db *sql.DB, clauses string, args ...interface{}) ([]*historyVisit, error) {
+
+	rows, err := db.Query(visitQuery+"\n\t\t"+clauses, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var visits []*historyVisit
+	for rows.Next() {
+		var (
+			hv = &historyVisit{}
+			ts float64
+		)
+		if err := rows.Scan(&hv.ID, &hv.URL, &hv.Title, &ts, &hv.VisitCount, &hv.RedirectFrom, &hv.RedirectTo); err != nil {
+			return nil, err
+		}
+		hv.Time = fromCoreData(ts)
+		visits = append(visits, hv)
+	}
+	return visits, rows.Err()
+}
+
+// historyVisits returns visits within dr, oldest first. If host is
+// non-empty, only visits to that host and its subdomains are returned.
+func historyVisits(dr dateRange, host string) ([]*historyVisit, error) {
+
+	start := time.Now()
+	conds, args := dateConds(dr)
+	if host != "" {
+		conds = append(conds, "i.url LIKE ?")
+		args = append(args, "%"+host+"%")
+	}
+
+	var clauses string
+	if len(conds) > 0 {
+		clauses = "WHERE " + strings.Join(conds, " AND ") + " "
+	}
+	clauses += "ORDER BY v.visit_time"
+
+	db, err := openSQLite(historyDB, true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	all, err := queryVisits(db, clauses, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		sq     = &searchQuery{}
+		visits []*historyVisit
+	)
+	if host != "" {
+		sq.Hosts = []string{strings.ToLower(host)}
+	}
+	for _, hv := range all {
+		if sq.matchHost(hv.URL) {
+			visits = append(visits, hv)
+		}
+	}
+	log.Printf("[history] %d visit(s) to %q %s in %v", len(visits), host, dr, time.Since(start))
+	return visits, nil
+}
+
+// dayVisits is the pages visited on one day.
+type dayVisits struct {
+	Day   time.Time // midnight local time
+	Pages []*pageVisits
+}
+
+// Count returns the number of visits on the day.
+func (dv *dayVisits) Count() int {
+	n := 0
+	for _, p := range dv.Pages {
+		n += p.Count
+	}
+	return n
+}
+
+// visitsByDay returns the pages visited on each (local) day within dr,
+// most recent day first. If dayOfMonth is not 0, only days with that
+// date, e.g. the 16th of each month, are returned.
+func visitsByDay(dr dateRange, dayOfMonth int) ([]*dayVisits, error) {
+
+	start := time.Now()
+	conds, args := dateConds(dr)
+	if dayOfMonth != 0 {
+		conds = append(conds, fmt.Sprintf("strftime('%%d', v.visit_time + %d, 'unixepoch', 'localtime') = ?",
+			coreDataEpoch))
+		args = append(args, fmt.Sprintf("%02d", dayOfMonth))
+	}
+	q := fmt.Sprintf(`
+		SELECT date(v.visit_time + %d, 'unixepoch', 'localtime') AS day,
+			i.url, IFNULL(v.title, ''), COUNT(v.id), MAX(v.visit_time)
+		FROM history_visits v JOIN history_items i ON v.history_item = i.id`, coreDataEpoch)
+	if len(conds) > 0 {
+		q += "\n\t\tWHERE " + strings.Join(conds, " AND ")
+	}
+	q += "\n\t\tGROUP BY day, i.id\n\t\tORDER BY day DESC"
+
+	db, err := openSQLite(historyDB, true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	// SQLite takes bare columns (v.title) from the row with MAX(visit_time)
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var (
+		days []*dayVisits
+		cur  *dayVisits
+	)
+	for rows.Next() {
+		var (
+			day string
+			p   = &pageVisits{}
+			ts  float64
+		)
+		if err := rows.Scan(&day, &p.URL, &p.Title, &p.Count, &ts); err != nil {
+			return nil, err
+		}
+		p.LastVisit = fromCoreData(ts)
+		if cur == nil || cur.Day.Format("2006-01-02") != day {
+			t, err := time.ParseInLocation("2006-01-02", day, time.Local)
+			if err != nil {
+				return nil, err
+			}
+			cur = &dayVisits{Day: t}
+			days = append(days, cur)
+		}
+		cur.Pages = append(cur.Pages, p)
+	}
+	log.Printf("[history] visits on %d day(s) in %v", len(days), time.Since(start))
+	return days, rows.Err()
+}
+
+// visitTimes returns the times of all visits within dr.
+func visitTimes(dr dateRange) ([]time.Time, error) {
+
+	conds, args := dateConds(dr)
+	q := "SELECT v.visit_time FROM history_visits v"
+	if len(conds) > 0 {
+		q += " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	db, err := openSQLite(historyDB, true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var times []time.Time
+	for rows.Next() {
+		var ts float64
+		if err := rows.Scan(&ts); err != nil {
+			return nil, err
+		}
+		times = append(times, fromCoreData(ts))
+	}
+	return times, rows.Err()
+}
+
+// firstVisits returns the time of the first visit to each URL in history.
+func firstVisits() (map[string]time.Time, error) {
+
+	db, err := openSQLite(historyDB, true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`
+		SELECT i.url, MIN(v.visit_time)
+		FROM history_visits v JOIN history_items i ON v.history_item = i.id
+		GROUP BY i.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	first := map[string]time.Time{}
+	for rows.Next() {
+		var (
+			URL string
+			ts  float64
+		)
+		if err := rows.Scan(&URL, &ts); err != nil {
+			return nil, err
+		}
+		first[URL] = fromCoreData(ts)
+	}
+	return first, rows.Err()
+}
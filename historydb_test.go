@@ -0,0 +1,109 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import "testing"
+
+func TestVisitStats(t *testing.T) {
+	withHistoryDB(t, func(_ string) {
+		urls := []string{
+			"https://golang.org", // history has https://golang.org/
+			"https://golang.org/",
+			"http://example.com", // history has https://www.example.com/
+			"https://example.com/page/",
+			"https://never.example.com/",
+			"javascript:void(0)",
+		}
+		stats, err := visitStats(urls)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			url   string
+			count int
+			last  float64
+		}{
+			{"https://golang.org", 2, 590100000},
+			{"https://golang.org/", 2, 590100000},
+			{"http://example.com", 2, 590200001},
+			{"https://example.com/page/", 1, 590300000},
+		}
+		for _, td := range tests {
+			vs, ok := stats[td.url]
+			if !ok {
+				t.Errorf("No stats for %q", td.url)
+				continue
+			}
+			if vs.Count != td.count {
+				t.Errorf("Bad count for %q. Expected=%d, Got=%d", td.url, td.count, vs.Count)
+			}
+			if x := fromCoreData(td.last); !vs.LastVisit.Equal(x) {
+				t.Errorf("Bad last visit for %q. Expected=%v, Got=%v", td.url, x, vs.LastVisit)
+			}
+		}
+		for _, s := range []string{"https://never.example.com/", "javascript:void(0)"} {
+			if vs, ok := stats[s]; ok {
+				t.Errorf("Unexpected stats for %q: %v", s, vs)
+			}
+		}
+		if len(stats) != len(tests) {
+			t.Errorf("Bad stats. Expected=%d, Got=%d", len(tests), len(stats))
+		}
+	})
+}
+
+func TestURLVariants(t *testing.T) {
+	tests := []struct {
+		in string
+		n  int
+	}{
+		{"https://golang.org", 8},
+		{"https://www.golang.org/doc/?q=1", 8},
+		{"javascript:void(0)", 1},
+		{"file:///etc/hosts", 1},
+	}
+	for _, td := range tests {
+		v := urlVariants(td.in)
+		if len(v) != td.n {
+			t.Errorf("Bad variants of %q. Expected=%d, Got=%d (%q)", td.in, td.n, len(v), v)
+		}
+		if v[0] != td.in {
+			t.Errorf("First variant of %q is %q", td.in, v[0])
+		}
+		for _, s := range v {
+			if normaliseURL(s) != normaliseURL(td.in) {
+				t.Errorf("Variant %q of %q doesn't match", s, td.in)
+			}
+		}
+	}
+}
+
+// Searches use historyDB, not the History.db go-safari opens.
+func TestSearchHistoryDB(t *testing.T) {
+	withHistoryDB(t, func(_ string) {
+		entries, err := searchHistory("", dateRange{}, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		x := []string{"https://example.com/page", "https://www.example.com/", "https://t.co/abc123"}
+		if len(entries) != len(x) {
+			t.Fatalf("Bad entries. Expected=%d, Got=%d", len(x), len(entries))
+		}
+		for i, e := range entries {
+			if e.URL != x[i] {
+				t.Errorf("Bad entry #%d. Expected=%q, Got=%q", i, x[i], e.URL)
+			}
+		}
+		if e := entries[0]; !e.Time.Equal(fromCoreData(590300000)) {
+			t.Errorf("Bad time. Expected=%v, Got=%v", fromCoreData(590300000), e.Time)
+		}
+
+		a := historyIndexPath()
+		historyDB = "/tmp/other/History.db"
+		if b := historyIndexPath(); a == b {
+			t.Errorf("Same history index for different databases: %s", a)
+		}
+	})
+}
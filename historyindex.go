@@ -0,0 +1,299 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/deanishe/go-safari/history"
+)
+
+// Name of history index in cache directory. Bump the version when the
+// schema changes.
+const historyIndexName = "history-index.v2.db"
+
+// The index is rebuilt from scratch after this long to drop pages that
+// Safari has expired from its history.
+const historyIndexMaxAge = 7 * 24 * time.Hour
+
+// Matches a field query, e.g. "title:golang".
+var ftsFieldRx = regexp.MustCompile(`^(title|url|host):(.+)$`)
+
+// errNoFTS5 is returned if SQLite was built without full-text search.
+var errNoFTS5 = errors.New("SQLite has no FTS5 module: build with -tags sqlite_fts5")
+
+// historyIndex is a full-text index of Safari's history with one row
+// per history item (URL). Rows' rowids are the IDs of the history items.
+// Table recent holds their last visit times, so the most recent pages can
+// be listed without scanning the full-text table.
+type historyIndex struct {
+	db *sql.DB
+}
+
+// historyIndexPath returns the path of the index for historyDB. Each
+// --history-db has its own index.
+func historyIndexPath() string {
+	return filepath.Join(wf.CacheDir(), cacheNameForPath(historyIndexName, historyDB))
+}
+
+// openHistoryIndex opens (and if necessary creates) the history index.
+func openHistoryIndex() (*historyIndex, error) {
+	db, err := openSQLite(historyIndexPath(), false)
+	if err != nil {
+		return nil, err
+	}
+	var fts5 bool
+	if err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5); err != nil || !fts5 {
+		db.Close()
+		if err != nil {
+			return nil, err
+		}
+		return nil, errNoFTS5
+	}
+	_, err = db.Exec(`
+		CREATE VIRTUAL TABLE IF NOT EXISTS pages USING fts5(
+			title, url, host, last_visit UNINDEXED,
+			tokenize = 'unicode61 remove_diacritics 2'
+		);
+		CREATE TABLE IF NOT EXISTS recent (id INTEGER PRIMARY KEY, last_visit REAL);
+		CREATE INDEX IF NOT EXISTS recent_last_visit ON recent (last_visit);
+		CREATE TABLE IF NOT EXISTS meta (key TEXT PRIMARY KEY, value);`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &historyIndex{db}, nil
+}
+
+// Close closes the index database.
+func (hi *historyIndex) Close() error { return hi.db.Close() }
+
+// meta returns an integer value from the meta table (0 if unset).
+func (hi *historyIndex) meta(key string) int64 {
+	var v int64
+	if err := hi.db.QueryRow("SELECT value FROM meta WHERE key = ?", key).Scan(&v); err != nil && err != sql.ErrNoRows {
+		log.Printf("[index] couldn't read %q: %v", key, err)
+	}
+	return v
+}
+
+// Sync adds visits newer than the last sync to the index. The index
+// is rebuilt if it's too old or Safari's history has been cleared.
+func (hi *historyIndex) Sync() error {
+
+	var (
+		start   = time.Now()
+		lastID  = hi.meta("last_visit_id")
+		builtAt = time.Unix(hi.meta("built_at"), 0)
+		maxID   int64
+	)
+
+	src, err := openSQLite(historyDB, true)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if err := src.QueryRow("SELECT IFNULL(MAX(id), 0) FROM history_visits").Scan(&maxID); err != nil {
+		return err
+	}
+	if maxID == lastID && time.Since(builtAt) < historyIndexMaxAge {
+		return nil
+	}
+
+	tx, err := hi.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if maxID < lastID || time.Since(builtAt) >= historyIndexMaxAge {
+		log.Printf("[index] rebuilding history index ...")
+		for _, table := range []string{"pages", "recent"} {
+			if _, err := tx.Exec("DELETE FROM " + table); err != nil {
+				return err
+			}
+		}
+		lastID = 0
+		builtAt = time.Now()
+	}
+
+	rows, err := src.Query(`
+		SELECT v.id, i.id, i.url, IFNULL(v.title, ''), v.visit_time
+		FROM history_visits v JOIN history_items i ON v.history_item = i.id
+		WHERE v.id > ?
+		ORDER BY v.id`, lastID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// Most recent visit for each item
+	type page struct {
+		URL, Title string
+		Time       float64
+	}
+	var (
+		pages = map[int64]*page{}
+		ids   []int64
+	)
+	for rows.Next() {
+		var (
+			visitID, itemID int64
+			p               = &page{}
+		)
+		if err := rows.Scan(&visitID, &itemID, &p.URL, &p.Title, &p.Time); err != nil {
+			return err
+		}
+		if prev, ok := pages[itemID]; !ok {
+			ids = append(ids, itemID)
+		} else if p.Title == "" {
+			p.Title = prev.Title
+		}
+		pages[itemID] = p
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		p := pages[id]
+		if p.Title == "" { // keep indexed title
+			tx.QueryRow("SELECT title FROM pages WHERE rowid = ?", id).Scan(&p.Title)
+		}
+		if _, err := tx.Exec("DELETE FROM pages WHERE rowid = ?", id); err != nil {
+			return err
+		}
+		var host string
+		if u, err := url.Parse(p.URL); err == nil {
+			host = u.Hostname()
+		}
+		if _, err := tx.Exec("INSERT INTO pages (rowid, title, url, host, last_visit) VALUES (?, ?, ?, ?, ?)",
+			id, p.Title, p.URL, host, p.Time); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("INSERT OR REPLACE INTO recent (id, last_visit) VALUES (?, ?)", id, p.Time); err != nil {
+			return err
+		}
+	}
+
+	for k, v := range map[string]int64{"last_visit_id": maxID, "built_at": builtAt.Unix()} {
+		if _, err := tx.Exec("INSERT OR REPLACE INTO meta (key, value) VALUES (?, ?)", k, v); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("[index] indexed %d page(s) in %v", len(ids), time.Since(start))
+	return nil
+}
+
+// Search returns pages matching query, best matches first, and their
+// scores (higher is better). An empty query returns the most recently
+// visited pages.
+func (hi *historyIndex) Search(query string, limit int) ([]*history.Entry, []float64, error) {
+
+	var (
+		start = time.Now()
+		expr  = ftsQuery(query)
+		rows  *sql.Rows
+		err   error
+	)
+	if expr == "" {
+		// CROSS JOIN makes SQLite walk recent's index instead of
+		// scanning the full-text table
+		rows, err = hi.db.Query(`
+			SELECT p.url, p.title, p.last_visit, 0
+			FROM recent r CROSS JOIN pages p ON p.rowid = r.id
+			ORDER BY r.last_visit DESC LIMIT ?`, limit)
+	} else {
+		rows, err = hi.db.Query(`
+			SELECT url, title, last_visit, rank FROM pages
+			WHERE pages MATCH ? ORDER BY rank LIMIT ?`, expr, limit)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var (
+		entries []*history.Entry
+		scores  []float64
+	)
+	for rows.Next() {
+		var (
+			e        = &history.Entry{}
+			ts, rank float64
+		)
+		if err := rows.Scan(&e.URL, &e.Title, &ts, &rank); err != nil {
+			return nil, nil, err
+		}
+		e.Time = fromCoreData(ts)
+		entries = append(entries, e)
+		scores = append(scores, -rank) // bm25 rank: lower is better
+	}
+	log.Printf("[index] %d page(s) for %q in %v", len(entries), expr, time.Since(start))
+	return entries, scores, rows.Err()
+}
+
+// ftsQuery converts a search query into an FTS5 query. Words match as
+// prefixes, "quoted phrases" match exactly and title:, url: and host:
+// restrict a word or phrase to that field. All terms must match.
+func ftsQuery(query string) string {
+	var terms []string
+	for _, tok := range tokenizeQuery(query) {
+		field := ""
+		if m := ftsFieldRx.FindStringSubmatch(tok); m != nil {
+			field, tok = m[1], m[2]
+		}
+
+		var term string
+		if len(tok) > 1 && strings.HasPrefix(tok, `"`) && strings.HasSuffix(tok, `"`) {
+			term = `"` + strings.Replace(tok[1:len(tok)-1], `"`, `""`, -1) + `"`
+		} else {
+			tok = strings.Trim(tok, `"*`)
+			if tok == "" {
+				continue
+			}
+			term = `"` + strings.Replace(tok, `"`, `""`, -1) + `"*`
+		}
+
+		if field != "" {
+			term = field + " : " + term
+		}
+		terms = append(terms, term)
+	}
+	return strings.Join(terms, " ")
+}
+
+// searchHistoryIndex syncs the history index and searches it.
+func searchHistoryIndex(query string, limit int) ([]*history.Entry, []float64, error) {
+	hi, err := openHistoryIndex()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer hi.Close()
+
+	if err := hi.Sync(); err != nil {
+		return nil, nil, err
+	}
+	return hi.Search(query, limit)
+}
+
+// resetHistoryIndex deletes the history index, so it's rebuilt on the
+// next search.
+func resetHistoryIndex() {
+	if err := os.Remove(historyIndexPath()); err != nil && !os.IsNotExist(err) {
+		log.Printf("[index] couldn't delete history index: %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Search should take less than this per keystroke.
+const historyIndexTarget = 50 * time.Millisecond
+
+func TestFTSQuery(t *testing.T) {
+	tests := []struct {
+		in, x string
+	}{
+		{"", ""},
+		{"go", `"go"*`},
+		{"go lang", `"go"* "lang"*`},
+		{`"go lang"`, `"go lang"`},
+		{"title:go", `title : "go"*`},
+		{`host:"example.com"`, `host : "example.com"`},
+		{`go* "`, `"go"*`},
+	}
+	for _, td := range tests {
+		if v := ftsQuery(td.in); v != td.x {
+			t.Errorf("ftsQuery(%q): expected=%q, got=%q", td.in, td.x, v)
+		}
+	}
+}
+
+// openTestIndex opens a fresh history index or skips the test if
+// SQLite was built without FTS5.
+func openTestIndex(t testing.TB) *historyIndex {
+	t.Helper()
+	resetHistoryIndex()
+	hi, err := openHistoryIndex()
+	if err == errNoFTS5 {
+		t.Skip("FTS5 unavailable: run tests with -tags sqlite_fts5")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hi
+}
+
+func TestHistoryIndex(t *testing.T) {
+	withHistoryDB(t, func(_ string) {
+		hi := openTestIndex(t)
+		defer resetHistoryIndex()
+		defer hi.Close()
+
+		if err := hi.Sync(); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			q string
+			x []string
+		}{
+			{"", []string{
+				"https://example.com/page",
+				"https://www.example.com/",
+				"https://t.co/abc123",
+				"https://golang.org/",
+				"https://blog.golang.org/go1.13",
+			}},
+			{"released", []string{"https://blog.golang.org/go1.13"}},
+			{"exam", []string{"https://www.example.com/", "https://example.com/page"}},
+			{"title:page", []string{"https://example.com/page"}},
+			{"host:blog", []string{"https://blog.golang.org/go1.13"}},
+			{"nothing", nil},
+		}
+		for _, td := range tests {
+			entries, scores, err := hi.Search(td.q, 10)
+			if err != nil {
+				t.Errorf("Search(%q): %v", td.q, err)
+				continue
+			}
+			if len(scores) != len(entries) {
+				t.Errorf("Search(%q): %d scores for %d entries", td.q, len(scores), len(entries))
+			}
+			var urls []string
+			for _, e := range entries {
+				urls = append(urls, e.URL)
+			}
+			if td.q == "exam" { // rank order isn't meaningful here
+				if len(urls) != len(td.x) {
+					t.Errorf("Search(%q): expected=%v, got=%v", td.q, td.x, urls)
+				}
+				continue
+			}
+			if !reflect.DeepEqual(urls, td.x) {
+				t.Errorf("Search(%q): expected=%v, got=%v", td.q, td.x, urls)
+			}
+		}
+	})
+}
+
+// BenchmarkHistoryIndexSearch measures searches (including the sync
+// check) of a history with 200,000 pages. "topic12" matches ~2,000 pages.
+func BenchmarkHistoryIndexSearch(b *testing.B) {
+	withHistoryDB(b, func(path string) {
+		db, err := openSQLite(path, false)
+		if err != nil {
+			b.Fatal(err)
+		}
+		_, err = db.Exec(`
+			WITH RECURSIVE n(i) AS (SELECT 100 UNION ALL SELECT i+1 FROM n WHERE i < 200100)
+			INSERT INTO history_items (id, url, domain_expansion, visit_count)
+			SELECT i, 'https://site' || (i % 500) || '.example.org/page/' || i, 'site', 1 FROM n;
+			WITH RECURSIVE n(i) AS (SELECT 100 UNION ALL SELECT i+1 FROM n WHERE i < 200100)
+			INSERT INTO history_visits (id, history_item, visit_time, title)
+			SELECT i, i, 590000000.0 + i, 'Page ' || i || ' about topic' || (i % 1000) FROM n;`)
+		db.Close()
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		hi := openTestIndex(b)
+		hi.Close()
+		defer resetHistoryIndex()
+
+		// Build index
+		if _, _, err := searchHistoryIndex("", 1); err != nil {
+			b.Fatal(err)
+		}
+
+		for _, q := range []string{"", "topic12", "title:topic12 site12"} {
+			b.Run(fmt.Sprintf("%q", q), func(b *testing.B) {
+				start := time.Now()
+				for i := 0; i < b.N; i++ {
+					if _, _, err := searchHistoryIndex(q, 250); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if d := time.Since(start) / time.Duration(b.N); d > historyIndexTarget {
+					b.Errorf("search took %v (target is %v)", d, historyIndexTarget)
+				}
+			})
+		}
+	})
+}
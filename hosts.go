@@ -0,0 +1,175 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	aw "github.com/deanishe/awgo"
+	"github.com/deanishe/go-safari/history"
+)
+
+// hostVisits is the history of a host.
+type hostVisits struct {
+	Host      string
+	Count     int // visits
+	Pages     int // distinct URLs
+	LastVisit time.Time
+}
+
+// doFilterHistoryHosts shows history aggregated by host, or the pages
+// of a single host if the query contains host:. Date tokens in the
+// query restrict the visits that are counted.
+func doFilterHistoryHosts() error {
+
+	showUpdateStatus()
+
+	text, dr := parseDateQuery(query, time.Now())
+	sq := parseQuery(text)
+	log.Printf("query=%q, hosts=%v, dates=%s", sq.Text, sq.Hosts, dr)
+
+	// Keep Alfred from re-ordering items based on usage
+	wf.Configure(aw.SuppressUIDs(true))
+
+	var err error
+	if len(sq.Hosts) > 0 {
+		err = hostPages(sq, dr)
+	} else {
+		err = allHosts(sq, dr)
+	}
+	if err != nil {
+		return err
+	}
+
+	if sq.Text != "" {
+		res := wf.Filter(sq.Text)
+		log.Printf("%d result(s) for %q", len(res), sq.Text)
+	}
+
+	wf.WarnEmpty("No history found", "Try a different query?")
+	wf.SendFeedback()
+	return nil
+}
+
+// allHosts adds an item for each host in history, most visited first.
+func allHosts(sq *searchQuery, dr dateRange) error {
+
+	pages, err := visitsByURL(dr, "")
+	if err != nil {
+		return err
+	}
+
+	hosts := groupByHost(pages)
+	log.Printf("%d host(s) in history", len(hosts))
+
+	dates := strings.Join(dr.Tokens, " ")
+	for _, hv := range hosts {
+		wf.NewItem(hv.Host).
+			Subtitle(fmt.Sprintf("%s · %s · Last visited %s",
+				plural(hv.Count, "visit"), plural(hv.Pages, "page"), relativeTime(hv.LastVisit))).
+			Autocomplete(strings.TrimSpace("host:"+hv.Host+" "+dates) + " ").
+			Icon(IconHistory).
+			Valid(false)
+	}
+	return nil
+}
+
+// hostPages adds an item for each page of the query's hosts, most
+// visited first.
+func hostPages(sq *searchQuery, dr dateRange) error {
+
+	pages, err := hostPageVisits(sq, dr)
+	if err != nil {
+		return err
+	}
+	log.Printf("%d page(s) for %v", len(pages), sq.Hosts)
+
+	if sq.Text == "" {
+		wf.NewItem("Back to All Hosts").
+			Autocomplete(strings.Join(dr.Tokens, " ")).
+			Icon(IconHome).
+			Valid(false)
+	}
+
+	for _, p := range pages {
+		e := &history.Entry{URL: p.URL, Title: p.Title, Time: p.LastVisit}
+		historyItem(e).
+			Subtitle(fmt.Sprintf("%s · Last visited %s · %s",
+				plural(p.Count, "visit"), relativeTime(p.LastVisit), p.URL))
+	}
+	return nil
+}
+
+// hostPageVisits returns the pages of the query's hosts, most visited
+// first. Pages matching several hosts, e.g. host:golang.org and
+// host:blog.golang.org, are only returned once.
+func hostPageVisits(sq *searchQuery, dr dateRange) ([]*pageVisits, error) {
+
+	var (
+		pages []*pageVisits
+		seen  = map[string]bool{}
+	)
+	for _, h := range sq.Hosts {
+		res, err := visitsByURL(dr, h)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range res {
+			if !seen[p.URL] && sq.matchHost(p.URL) {
+				seen[p.URL] = true
+				pages = append(pages, p)
+			}
+		}
+	}
+	sort.SliceStable(pages, func(i, j int) bool { return pages[i].Count > pages[j].Count })
+	return pages, nil
+}
+
+// groupByHost aggregates page visits by host, most visited first.
+func groupByHost(pages []*pageVisits) []*hostVisits {
+
+	byHost := map[string]*hostVisits{}
+	for _, p := range pages {
+		u, err := url.Parse(p.URL)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+		host := strings.ToLower(u.Hostname())
+		hv, ok := byHost[host]
+		if !ok {
+			hv = &hostVisits{Host: host}
+			byHost[host] = hv
+		}
+		hv.Count += p.Count
+		hv.Pages++
+		if p.LastVisit.After(hv.LastVisit) {
+			hv.LastVisit = p.LastVisit
+		}
+	}
+
+	hosts := make([]*hostVisits, 0, len(byHost))
+	for _, hv := range byHost {
+		hosts = append(hosts, hv)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		if hosts[i].Count != hosts[j].Count {
+			return hosts[i].Count > hosts[j].Count
+		}
+		return hosts[i].Host < hosts[j].Host
+	})
+	return hosts
+}
+
+// plural returns "1 <unit>" or "N <unit>s".
+func plural(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHostPageVisits(t *testing.T) {
+	tests := []struct {
+		query string
+		x     []string
+	}{
+		{"host:golang.org", []string{"https://golang.org/", "https://blog.golang.org/go1.13"}},
+		{"host:blog.golang.org", []string{"https://blog.golang.org/go1.13"}},
+		// Overlapping hosts list each page once
+		{"host:golang.org host:blog.golang.org", []string{"https://golang.org/", "https://blog.golang.org/go1.13"}},
+		{"host:example.com host:t.co", []string{"https://www.example.com/", "https://example.com/page", "https://t.co/abc123"}},
+		{"host:nothing.example", nil},
+	}
+
+	withHistoryDB(t, func(_ string) {
+		for _, td := range tests {
+			pages, err := hostPageVisits(parseQuery(td.query), dateRange{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var v []string
+			for _, p := range pages {
+				v = append(v, p.URL)
+			}
+			if !reflect.DeepEqual(v, td.x) {
+				t.Errorf("Bad pages for %q. Expected=%q, Got=%q", td.query, td.x, v)
+			}
+		}
+	})
+}
+
+func TestGroupByHost(t *testing.T) {
+	withHistoryDB(t, func(_ string) {
+		pages, err := visitsByURL(dateRange{}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v []hostVisits
+		for _, hv := range groupByHost(pages) {
+			v = append(v, hostVisits{Host: hv.Host, Count: hv.Count, Pages: hv.Pages})
+		}
+		x := []hostVisits{
+			{Host: "golang.org", Count: 2, Pages: 1},
+			{Host: "www.example.com", Count: 2, Pages: 1},
+			{Host: "blog.golang.org", Count: 1, Pages: 1},
+			{Host: "example.com", Count: 1, Pages: 1},
+			{Host: "t.co", Count: 1, Pages: 1},
+		}
+		if !reflect.DeepEqual(v, x) {
+			t.Errorf("Bad hosts.\nExpected=%+v\nGot=%+v", x, v)
+		}
+	})
+}
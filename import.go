@@ -0,0 +1,386 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	aw "github.com/deanishe/awgo"
+	"github.com/pkg/errors"
+)
+
+var (
+	// Tags in a Netscape bookmarks file that matter for its structure
+	netscapeTagRx = regexp.MustCompile(`(?is)<h3[^>]*>(.*?)</h3>|<a\s[^>]*?href="([^"]*)"[^>]*>(.*?)</a>|<dl[^>]*>|</dl>`)
+	sqliteMagic   = []byte("SQLite format 3\x00")
+
+	// Titles of Firefox's root folders, keyed by GUID. The tags
+	// root isn't listed, as it doesn't contain real bookmarks.
+	firefoxRoots = map[string]string{
+		"menu________": "Bookmarks Menu",
+		"toolbar_____": "Bookmarks Toolbar",
+		"unfiled_____": "Other Bookmarks",
+		"mobile______": "Mobile Bookmarks",
+	}
+	firefoxQuery = `
+		SELECT b.id, b.parent, b.type, IFNULL(b.title, ''), IFNULL(b.guid, ''), IFNULL(p.url, '')
+		FROM moz_bookmarks b LEFT JOIN moz_places p ON b.fk = p.id
+		ORDER BY b.parent, b.position`
+)
+
+// importNode is a bookmark or folder read from another browser's export.
+type importNode struct {
+	Title    string
+	URL      string // empty for folders
+	Children []*importNode
+}
+
+// importedBookmark is a bookmark added (or to be added) by an import.
+type importedBookmark struct {
+	Path  string // folder the bookmark is added to
+	Title string
+	URL   string
+}
+
+// doImportBookmarks merges bookmarks from another browser into Safari's
+// Bookmarks.plist, or shows what would be added.
+func doImportBookmarks() error {
+
+	log.Printf("file=%q, into=%q, preview=%v", importFile, importFolder, importPreview)
+
+	if !importPreview {
+		wf.Configure(aw.TextErrors(true))
+	}
+
+	src, err := loadImportFile(importFile)
+	if err != nil {
+		return err
+	}
+
+	bf, err := loadBookmarksFile(bookmarksPlist)
+	if err != nil {
+		return err
+	}
+
+	added, skipped, err := mergeImport(bf, src, importFolder)
+	if err != nil {
+		return err
+	}
+	log.Printf("%d new bookmark(s), %d duplicate(s) in %q", len(added), skipped, importFile)
+
+	if importPreview {
+		return previewImport(added, skipped)
+	}
+
+	if len(added) == 0 {
+		fmt.Println("No new bookmarks to import")
+		return nil
+	}
+	if err := bf.Save(); err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d bookmark(s) into %s\n", len(added), importFolder)
+	return nil
+}
+
+// previewImport sends the bookmarks an import would add to Alfred.
+func previewImport(added []importedBookmark, skipped int) error {
+
+	if query == "" {
+		wf.Configure(aw.SuppressUIDs(true))
+		it := wf.NewItem(fmt.Sprintf("Import %d Bookmark(s) into \"%s\"", len(added), importFolder)).
+			Subtitle(fmt.Sprintf("%d duplicate(s) will be skipped", skipped)).
+			Icon(IconBookmark).
+			Arg(importFile).
+			Valid(len(added) > 0).
+			Var("ALSF_INTO", importFolder).
+			Var("action", "import")
+
+		if len(added) == 0 {
+			it.Subtitle(fmt.Sprintf("All %d bookmark(s) are already in Safari", skipped))
+		}
+	}
+
+	for _, bm := range added {
+		wf.NewItem(bm.Title).
+			Subtitle(fmt.Sprintf("%s // %s", bm.Path, bm.URL)).
+			Match(bm.Title + " " + urlKeywords(bm.URL)).
+			Copytext(bm.URL).
+			Icon(IconBookmark).
+			Valid(false)
+	}
+
+	if query != "" {
+		res := wf.Filter(query)
+		log.Printf("%d bookmark(s) for %q", len(res), query)
+	}
+
+	wf.WarnEmpty("No bookmarks to import", "Try a different query?")
+	wf.SendFeedback()
+	return nil
+}
+
+// mergeImport adds the bookmarks in src to the folder into (created if
+// necessary), preserving src's folder structure. Bookmarks whose URL is
+// already in Safari (ignoring the Reading List) or earlier in src are
+// skipped, as are folders left empty as a result. It returns the added
+// bookmarks and the number skipped.
+func mergeImport(bf *bookmarksFile, src *importNode, into string) ([]importedBookmark, int, error) {
+
+	var (
+		added   []importedBookmark
+		skipped int
+		seen    = map[string]bool{}
+	)
+
+	bf.Root.Walk(func(n plistNode, path []string) {
+		if n.IsBookmark() && (len(path) == 0 || path[0] != topLevelNames[readingListName]) {
+			seen[normaliseURL(n.URL())] = true
+		}
+	})
+
+	dest, err := bf.Folder(into, true)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// convert returns the Safari nodes for src's children
+	var convert func(src *importNode, path string) []plistNode
+	convert = func(src *importNode, path string) []plistNode {
+		var nodes []plistNode
+		for _, n := range src.Children {
+			if n.URL == "" {
+				p := path + "/" + n.Title
+				children := convert(n, p)
+				if len(children) == 0 {
+					continue
+				}
+				f := newListNode(n.Title)
+				f.SetChildren(children)
+				nodes = append(nodes, f)
+				continue
+			}
+
+			k := normaliseURL(n.URL)
+			if seen[k] {
+				skipped++
+				continue
+			}
+			seen[k] = true
+
+			title := n.Title
+			if title == "" {
+				title = n.URL
+			}
+			nodes = append(nodes, newLeafNode(title, n.URL))
+			added = append(added, importedBookmark{Path: path, Title: title, URL: n.URL})
+		}
+		return nodes
+	}
+
+	dest.Append(convert(src, strings.Join(splitFolderPath(into), "/"))...)
+	return added, skipped, nil
+}
+
+// loadImportFile reads a Netscape HTML bookmarks file, Chrome's
+// Bookmarks JSON file or Firefox's places.sqlite.
+func loadImportFile(path string) (*importNode, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var root *importNode
+	switch {
+	case bytes.HasPrefix(data, sqliteMagic):
+		root, err = parseFirefoxBookmarks(path)
+	case bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")):
+		root, err = parseChromeBookmarks(data)
+	default:
+		root, err = parseNetscapeBookmarks(data)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, filepath.Base(path))
+	}
+	return root, nil
+}
+
+// parseNetscapeBookmarks parses the HTML bookmarks format that every
+// browser can export. Folders are <H3> headings followed by a <DL>
+// containing their bookmarks.
+func parseNetscapeBookmarks(data []byte) (*importNode, error) {
+
+	var (
+		root    = &importNode{}
+		stack   = []*importNode{}
+		pending *importNode // folder whose <DL> hasn't been seen yet
+		cur     = root
+	)
+
+	for _, m := range netscapeTagRx.FindAllSubmatch(data, -1) {
+		tag := strings.ToLower(string(m[0][:3]))
+		switch {
+		case tag == "<h3":
+			pending = &importNode{Title: html.UnescapeString(strings.TrimSpace(string(m[1])))}
+			cur.Children = append(cur.Children, pending)
+
+		case tag == "<dl":
+			stack = append(stack, cur)
+			if pending != nil {
+				cur, pending = pending, nil
+			}
+
+		case tag == "</d":
+			if len(stack) == 0 {
+				return nil, errors.New("unbalanced </DL>")
+			}
+			cur, stack = stack[len(stack)-1], stack[:len(stack)-1]
+
+		default: // <a
+			cur.Children = append(cur.Children, &importNode{
+				Title: html.UnescapeString(strings.TrimSpace(string(m[3]))),
+				URL:   html.UnescapeString(string(m[2])),
+			})
+		}
+	}
+
+	if len(root.Children) == 0 {
+		return nil, errors.New("no bookmarks found")
+	}
+	return root, nil
+}
+
+// chromeNode is a bookmark or folder in Chrome's Bookmarks file.
+type chromeNode struct {
+	Name     string        `json:"name"`
+	Type     string        `json:"type"`
+	URL      string        `json:"url"`
+	Children []*chromeNode `json:"children"`
+}
+
+// parseChromeBookmarks parses the JSON file Chrome stores bookmarks in.
+func parseChromeBookmarks(data []byte) (*importNode, error) {
+
+	var file struct {
+		Roots map[string]json.RawMessage `json:"roots"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	var convert func(cn *chromeNode) *importNode
+	convert = func(cn *chromeNode) *importNode {
+		n := &importNode{Title: cn.Name}
+		if cn.Type == "url" {
+			n.URL = cn.URL
+			return n
+		}
+		for _, c := range cn.Children {
+			n.Children = append(n.Children, convert(c))
+		}
+		return n
+	}
+
+	root := &importNode{}
+	// Use a fixed order, as map order is random
+	for _, k := range []string{"bookmark_bar", "other", "synced"} {
+		raw, ok := file.Roots[k]
+		if !ok {
+			continue
+		}
+		cn := &chromeNode{}
+		if err := json.Unmarshal(raw, cn); err != nil {
+			return nil, errors.Wrap(err, k)
+		}
+		root.Children = append(root.Children, convert(cn))
+	}
+
+	if len(root.Children) == 0 {
+		return nil, errors.New("no bookmark roots found")
+	}
+	return root, nil
+}
+
+// parseFirefoxBookmarks reads bookmarks from Firefox's places.sqlite.
+// The database is opened read-only, so Firefox may be running.
+func parseFirefoxBookmarks(path string) (*importNode, error) {
+
+	db, err := openSQLite(path, true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(firefoxQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var (
+		nodes    = map[int64]*importNode{}
+		children = map[int64][]int64{}
+		roots    []int64
+	)
+
+	for rows.Next() {
+		var (
+			id, parent, typ  int64
+			title, guid, URL string
+		)
+		if err := rows.Scan(&id, &parent, &typ, &title, &guid, &URL); err != nil {
+			return nil, err
+		}
+
+		if t, ok := firefoxRoots[guid]; ok {
+			nodes[id] = &importNode{Title: t}
+			roots = append(roots, id)
+			continue
+		}
+
+		switch typ {
+		case 1: // bookmark
+			if strings.HasPrefix(URL, "place:") { // saved queries
+				continue
+			}
+			nodes[id] = &importNode{Title: title, URL: URL}
+		case 2: // folder
+			nodes[id] = &importNode{Title: title}
+		default: // separator
+			continue
+		}
+		children[parent] = append(children[parent], id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Build tree. Nodes are ordered by position within each parent.
+	for id, ids := range children {
+		p, ok := nodes[id]
+		if !ok { // not a bookmark folder
+			continue
+		}
+		for _, c := range ids {
+			p.Children = append(p.Children, nodes[c])
+		}
+	}
+
+	root := &importNode{}
+	for _, id := range roots {
+		root.Children = append(root.Children, nodes[id])
+	}
+	if len(root.Children) == 0 {
+		return nil, errors.New("no bookmark roots found")
+	}
+	return root, nil
+}
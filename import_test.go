@@ -0,0 +1,144 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// flatten returns the "/"-separated paths of the bookmarks in n.
+func flatten(n *importNode) []string {
+	var (
+		paths []string
+		walk  func(n *importNode, path string)
+	)
+	walk = func(n *importNode, path string) {
+		for _, c := range n.Children {
+			if c.URL != "" {
+				paths = append(paths, path+c.Title+" <"+c.URL+">")
+				continue
+			}
+			walk(c, path+c.Title+"/")
+		}
+	}
+	walk(n, "")
+	return paths
+}
+
+func TestLoadImportFile(t *testing.T) {
+	tests := []struct {
+		name string
+		x    []string
+	}{
+		{"bookmarks.html", []string{
+			"Bookmarks bar/The Go Programming Language <https://golang.org/>",
+			"Bookmarks bar/Alfred <https://www.alfredapp.com/>",
+			"Bookmarks bar/News & Blogs/Hacker News <https://news.ycombinator.com/>",
+			"Bookmarks bar/News & Blogs/The Go Blog <https://blog.golang.org/?a=1&b=2>",
+			"Example <https://example.com/>",
+		}},
+		{"chrome-bookmarks.json", []string{
+			"Bookmarks bar/The Go Programming Language <https://golang.org/>",
+			"Bookmarks bar/News/Hacker News <https://news.ycombinator.com/>",
+			"Other bookmarks/Example <https://example.com/>",
+		}},
+	}
+
+	for _, td := range tests {
+		t.Run(td.name, func(t *testing.T) {
+			root, err := loadImportFile(filepath.Join("testdata", td.name))
+			if err != nil {
+				t.Fatalf("load %q: %v", td.name, err)
+			}
+			if v := flatten(root); !reflect.DeepEqual(v, td.x) {
+				t.Errorf("Expected=%q, Got=%q", td.x, v)
+			}
+		})
+	}
+}
+
+func TestLoadImportFileInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alsf-import-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, s := range []string{"", "<p>not bookmarks</p>", "<DL><p></DL></DL>", `{"roots": {}}`} {
+		path := filepath.Join(dir, "bookmarks")
+		if err := ioutil.WriteFile(path, []byte(s), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadImportFile(path); err == nil {
+			t.Errorf("Accepted invalid file %q", s)
+		}
+	}
+}
+
+func TestMergeImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alsf-import-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := copyFile(t, "Bookmarks.plist", dir)
+	bf, err := loadBookmarksFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := loadImportFile(filepath.Join("testdata", "bookmarks.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	added, skipped, err := mergeImport(bf, src, "Bookmarks Menu/Imported")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// golang.org is already in Favorites. Reading List doesn't count.
+	x := []importedBookmark{
+		{"Bookmarks Menu/Imported/Bookmarks bar", "Alfred", "https://www.alfredapp.com/"},
+		{"Bookmarks Menu/Imported/Bookmarks bar/News & Blogs", "Hacker News", "https://news.ycombinator.com/"},
+		{"Bookmarks Menu/Imported/Bookmarks bar/News & Blogs", "The Go Blog", "https://blog.golang.org/?a=1&b=2"},
+		{"Bookmarks Menu/Imported", "Example", "https://example.com/"},
+	}
+	if !reflect.DeepEqual(added, x) {
+		t.Errorf("Bad added. Expected=%v, Got=%v", x, added)
+	}
+	if skipped != 1 {
+		t.Errorf("Bad skipped. Expected=1, Got=%d", skipped)
+	}
+
+	if err := bf.Save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	// Re-importing adds nothing
+	bf, err = loadBookmarksFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var folders []string
+	bf.Root.Walk(func(n plistNode, path []string) {
+		if n.IsFolder() && len(path) > 0 && path[0] == "Bookmarks Menu" {
+			folders = append(folders, n.Title())
+		}
+	})
+	xf := []string{"Imported", "Bookmarks bar", "News & Blogs"}
+	if !reflect.DeepEqual(folders, xf) {
+		t.Errorf("Bad folders. Expected=%q, Got=%q", xf, folders)
+	}
+	added, skipped, err = mergeImport(bf, src, "Bookmarks Menu/Imported")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != 0 || skipped != 5 {
+		t.Errorf("Re-import added %d, skipped %d", len(added), skipped)
+	}
+}
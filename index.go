@@ -0,0 +1,304 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/gob"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	safari "github.com/deanishe/go-safari"
+)
+
+// Name of bookmark index in cache directory. Bump the version when
+// the index format changes.
+const indexCacheName = "bookmarks-index.v3.gob"
+
+// Index loaded by loadIndex.
+var bmIndex *bookmarkIndex
+
+// bookmarkIndex is a flattened copy of Bookmarks.plist that is cached
+// between runs and is much faster to load than the plist.
+type bookmarkIndex struct {
+	ModTime   time.Time // of Bookmarks.plist
+	Size      int64     // of Bookmarks.plist
+	Bookmarks []*indexBookmark
+	Folders   []*indexFolder
+}
+
+// indexBookmark is a bookmark, bookmarklet or Reading List entry.
+type indexBookmark struct {
+	UID         string
+	RawTitle    string   // title incl. #tags
+	Title       string   // title without #tags
+	Tags        []string // #tags from title
+	URL         string
+	Keywords    string   // Title + hostname for fuzzy matching
+	Path        []string // titles of containing folders
+	Parent      string   // UID of containing folder
+	Pos         int      // position in containing folder
+	Bookmarklet bool
+	ReadingList bool
+
+	// Reading List entries only
+	Preview        string
+	DateAdded      time.Time
+	DateLastViewed time.Time // zero if unread
+}
+
+// Read returns true if a Reading List entry has been read.
+func (bm *indexBookmark) Read() bool { return !bm.DateLastViewed.IsZero() }
+
+// indexFolder is a bookmark folder.
+type indexFolder struct {
+	UID          string
+	Title        string
+	Path         []string // titles of ancestors
+	Parent       string   // UID of parent folder
+	Pos          int      // position in parent folder
+	NumBookmarks int      // bookmarks and bookmarklets in the folder
+	NumFolders   int      // direct subfolders
+	NumOpen      int      // bookmarks (not bookmarklets) in the folder
+	NumOpenAll   int      // bookmarks in the folder and its subfolders
+}
+
+// FullPath returns the titles of the Folder's ancestors and the Folder itself.
+func (f *indexFolder) FullPath() []string {
+	return append(append([]string{}, f.Path...), f.Title)
+}
+
+// Folder returns the folder with the given UID or nil.
+func (idx *bookmarkIndex) Folder(uid string) *indexFolder {
+	for _, f := range idx.Folders {
+		if f.UID == uid {
+			return f
+		}
+	}
+	return nil
+}
+
+// Ancestors returns a folder's ancestors, top-level folder first.
+func (idx *bookmarkIndex) Ancestors(f *indexFolder) []*indexFolder {
+	var folders []*indexFolder
+	for p := idx.Folder(f.Parent); p != nil; p = idx.Folder(p.Parent) {
+		folders = append([]*indexFolder{p}, folders...)
+	}
+	return folders
+}
+
+// Contents returns the subfolders and bookmarks in the folder with
+// the given UID, each in Safari's order.
+func (idx *bookmarkIndex) Contents(uid string) ([]*indexFolder, []*indexBookmark) {
+	var (
+		folders []*indexFolder
+		bms     []*indexBookmark
+	)
+	for _, f := range idx.Folders {
+		if f.Parent == uid {
+			folders = append(folders, f)
+		}
+	}
+	for _, bm := range idx.Bookmarks {
+		if bm.Parent == uid {
+			bms = append(bms, bm)
+		}
+	}
+	return folders, bms
+}
+
+// cacheNameForPath inserts a hash of path into cache filename name,
+// so each source file gets its own cache.
+func cacheNameForPath(name, path string) string {
+	if p, err := filepath.Abs(path); err == nil {
+		path = p
+	}
+	x := filepath.Ext(name)
+	h := sha1.Sum([]byte(path))
+	return fmt.Sprintf("%s-%x%s", strings.TrimSuffix(name, x), h[:4], x)
+}
+
+// loadIndex returns the bookmark index, rebuilding it if Bookmarks.plist
+// has changed since the cached copy was created. Each --bookmarks-plist
+// has its own cached index.
+func loadIndex() (*bookmarkIndex, error) {
+	if bmIndex != nil {
+		return bmIndex, nil
+	}
+
+	start := time.Now()
+	fi, err := os.Stat(bookmarksPlist)
+	if err != nil {
+		return nil, err
+	}
+
+	name := cacheNameForPath(indexCacheName, bookmarksPlist)
+	if data, err := wf.Cache.Load(name); err == nil {
+		idx := &bookmarkIndex{}
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(idx); err != nil {
+			log.Printf("[index] invalid cache: %v", err)
+		} else if idx.ModTime.Equal(fi.ModTime()) && idx.Size == fi.Size() {
+			log.Printf("[index] hit: %d bookmarks, %d folders in %v",
+				len(idx.Bookmarks), len(idx.Folders), time.Since(start))
+			bmIndex = idx
+			return idx, nil
+		}
+	}
+
+	bf, err := loadBookmarksFile(bookmarksPlist)
+	if err != nil {
+		return nil, err
+	}
+	idx := buildIndex(bf)
+	idx.ModTime, idx.Size = fi.ModTime(), fi.Size()
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(idx); err != nil {
+		return nil, err
+	}
+	if err := wf.Cache.Store(name, buf.Bytes()); err != nil {
+		return nil, err
+	}
+	log.Printf("[index] miss: rebuilt with %d bookmarks, %d folders in %v",
+		len(idx.Bookmarks), len(idx.Folders), time.Since(start))
+
+	bmIndex = idx
+	return idx, nil
+}
+
+// buildIndex flattens a Bookmarks.plist.
+func buildIndex(bf *bookmarksFile) *bookmarkIndex {
+
+	var (
+		idx     = &bookmarkIndex{}
+		rlTitle = topLevelNames[readingListName]
+		walk    func(n plistNode, parents []*indexFolder, inRL bool)
+	)
+
+	walk = func(n plistNode, parents []*indexFolder, inRL bool) {
+		path := make([]string, len(parents))
+		for i, f := range parents {
+			path[i] = f.Title
+		}
+		if inRL {
+			path = []string{rlTitle}
+		}
+
+		for i, c := range n.Children() {
+			if c.IsFolder() {
+				if inRL {
+					continue
+				}
+				if c.Title() == rlTitle && len(parents) == 0 {
+					walk(c, nil, true)
+					continue
+				}
+
+				f := &indexFolder{UID: c.UID(), Title: c.Title(), Path: path, Parent: n.UID(), Pos: i}
+				for _, c2 := range c.Children() {
+					if c2.IsFolder() {
+						f.NumFolders++
+					} else if c2.IsBookmark() {
+						f.NumBookmarks++
+					}
+				}
+				idx.Folders = append(idx.Folders, f)
+				walk(c, append(parents[:len(parents):len(parents)], f), false)
+				continue
+			}
+
+			if !c.IsBookmark() {
+				continue
+			}
+
+			bm := newIndexBookmark(c.UID(), c.Title(), c.URL(), path)
+			bm.Parent, bm.Pos = n.UID(), i
+			if inRL {
+				rl := c.dict("ReadingList", true)
+				bm.ReadingList = true
+				bm.Preview = rl.str("PreviewText")
+				bm.DateAdded, _ = rl["DateAdded"].(time.Time)
+				bm.DateLastViewed, _ = rl["DateLastViewed"].(time.Time)
+			}
+			idx.Bookmarks = append(idx.Bookmarks, bm)
+
+			if bm.Bookmarklet || bm.ReadingList {
+				continue
+			}
+			// Update counts of bookmarks to open
+			for i, f := range parents {
+				f.NumOpenAll++
+				if i == len(parents)-1 {
+					f.NumOpen++
+				}
+			}
+		}
+	}
+
+	walk(bf.Root, nil, false)
+	return idx
+}
+
+// newIndexBookmark creates an indexBookmark.
+func newIndexBookmark(uid, title, URL string, path []string) *indexBookmark {
+	name, tags := parseTags(title)
+	bm := &indexBookmark{
+		UID:         uid,
+		RawTitle:    title,
+		Title:       name,
+		Tags:        tags,
+		URL:         URL,
+		Keywords:    name,
+		Path:        path,
+		Bookmarklet: strings.HasPrefix(URL, "javascript:"),
+	}
+	if u, err := url.Parse(URL); err == nil && u.Hostname() != "" {
+		bm.Keywords += " " + u.Hostname()
+	}
+	return bm
+}
+
+// indexBookmarkFromSafari converts a go-safari Bookmark.
+func indexBookmarkFromSafari(bm *safari.Bookmark, path []string) *indexBookmark {
+	ib := newIndexBookmark(bm.UID(), bm.Title(), bm.URL, path)
+	ib.Bookmarklet = bm.IsBookmarklet()
+	ib.ReadingList = bm.InReadingList()
+	ib.Preview = bm.Preview
+	return ib
+}
+
+// indexFolderFromSafari converts a go-safari Folder.
+func indexFolderFromSafari(f *safari.Folder) *indexFolder {
+	p := folderPath(f)
+	return &indexFolder{
+		UID:          f.UID(),
+		Title:        f.Title(),
+		Path:         p[:len(p)-1],
+		NumBookmarks: len(f.Bookmarks),
+		NumFolders:   len(f.Folders),
+		NumOpen:      len(folderBookmarks(f, false)),
+		NumOpenAll:   len(folderBookmarks(f, true)),
+	}
+}
+
+// indexBookmarks returns the indexed bookmarks for which accept returns true.
+func indexBookmarks(accept func(bm *indexBookmark) bool) ([]*indexBookmark, error) {
+	idx, err := loadIndex()
+	if err != nil {
+		return nil, err
+	}
+	var bms []*indexBookmark
+	for _, bm := range idx.Bookmarks {
+		if accept(bm) {
+			bms = append(bms, bm)
+		}
+	}
+	return bms, nil
+}
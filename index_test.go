@@ -0,0 +1,144 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// withBookmarksPlist calls fn with bookmarksPlist set to path and
+// no index loaded.
+func withBookmarksPlist(path string, fn func()) {
+	prevPath, prevIdx := bookmarksPlist, bmIndex
+	bookmarksPlist, bmIndex = path, nil
+	defer func() { bookmarksPlist, bmIndex = prevPath, prevIdx }()
+	fn()
+}
+
+func TestIndexContents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alsf-index-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := copyFile(t, "Bookmarks.plist", dir)
+	bf, err := loadBookmarksFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bar, err := bf.Folder(bookmarksBarName, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := bf.Folder("Favorites/Docs/Go", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bar.Append(newLeafNode("Blog", "https://blog.golang.org/"))
+	sub.Append(newLeafNode("Spec", "https://golang.org/ref/spec"))
+	if err := bf.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	withBookmarksPlist(path, func() {
+		idx, err := loadIndex()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var (
+			uid = bf.Root.Children()[1].UID() // Favorites
+			v   []string
+		)
+		for _, n := range favoriteNodes(idx, uid) {
+			if n.Folder != nil {
+				v = append(v, fmt.Sprintf("%d folder %s", n.Pos, n.Folder.Title))
+			} else {
+				v = append(v, fmt.Sprintf("%d bookmark %s", n.Pos, n.Bookmark.Title))
+			}
+		}
+		x := []string{
+			"0 bookmark The Go Programming Language",
+			"1 folder Docs",
+			"2 bookmark Blog",
+		}
+		if !reflect.DeepEqual(v, x) {
+			t.Errorf("Bad Favorites. Expected=%q, Got=%q", x, v)
+		}
+
+		f := idx.Folder(sub.UID())
+		if f == nil {
+			t.Fatalf("folder %s not in index", sub.UID())
+		}
+		v = nil
+		for _, p := range idx.Ancestors(f) {
+			v = append(v, p.Title)
+		}
+		x = []string{"Favorites", "Docs"}
+		if !reflect.DeepEqual(v, x) {
+			t.Errorf("Bad ancestors. Expected=%q, Got=%q", x, v)
+		}
+		if _, bms := idx.Contents(f.UID); len(bms) != 1 || bms[0].Title != "Spec" {
+			t.Errorf("Bad contents of %q: %v", f.Title, bms)
+		}
+	})
+}
+
+// Each Bookmarks.plist has its own cached index, even if the files'
+// sizes and modification times are identical.
+func TestIndexCachePerPlist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alsf-index-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		pathA = copyFile(t, "Bookmarks.plist", dir)
+		pathB = filepath.Join(dir, "Other.plist")
+	)
+	data, err := ioutil.ReadFile(pathA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = []byte(strings.Replace(string(data), "<string>Example</string>", "<string>Elpmaxe</string>", 1))
+	if err := ioutil.WriteFile(pathB, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(pathA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(pathB, fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path, title string
+	}{
+		{pathA, "Example"},
+		{pathB, "Elpmaxe"},
+		{pathA, "Example"}, // cached
+		{pathB, "Elpmaxe"}, // cached
+	}
+	for _, td := range tests {
+		td := td
+		withBookmarksPlist(td.path, func() {
+			bms, err := indexBookmarks(func(bm *indexBookmark) bool { return bm.ReadingList })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(bms) != 1 || bms[0].Title != td.title {
+				t.Errorf("Bad index for %s. Expected=%q, Got=%v", filepath.Base(td.path), td.title, bms)
+			}
+		})
+	}
+}
@@ -27,6 +27,8 @@ var (
 	info     *build.Info
 	buildDir = "./build"
 	distDir  = "./dist"
+	// go-sqlite3 only includes FTS5 (used by the history index) with this tag
+	buildTags = "sqlite_fts5"
 )
 
 func init() {
@@ -47,6 +49,7 @@ var Aliases = map[string]interface{}{
 	"c": Clean,
 	"d": Dist,
 	"l": Link,
+	"t": Test,
 }
 
 // Build builds workflow in ./build
@@ -54,7 +57,7 @@ func Build() error {
 	mg.Deps(cleanBuild, Icons)
 
 	if err := sh.RunWith(info.Env(),
-		"go", "build", "-o", "./build/alsf", ".",
+		"go", "build", "-tags", buildTags, "-o", "./build/alsf", ".",
 	); err != nil {
 		return err
 	}
@@ -79,6 +82,25 @@ func Run() error {
 	return sh.RunWith(info.Env(), "./build/alsf", "-h")
 }
 
+// Test run unit tests
+func Test() error {
+	fmt.Println("running tests ...")
+	return sh.RunWith(info.Env(), "go", "test", "-tags", buildTags, "-v", ".")
+}
+
+// Bench run benchmarks
+func Bench() error {
+	fmt.Println("running benchmarks ...")
+	return sh.RunWith(info.Env(),
+		"go", "test", "-tags", buildTags, "-run", "XXX", "-bench", ".", "-benchmem", ".",
+	)
+}
+
+// Vet check code
+func Vet() error {
+	return sh.RunWith(info.Env(), "go", "vet", "-tags", buildTags, ".")
+}
+
 // Dist build an .alfredworkflow file in ./dist
 func Dist() error {
 	mg.SerialDeps(Build)
@@ -103,7 +125,7 @@ func Icons() error {
 	var (
 		green  = "00e756"
 		yellow = "f8ac30"
-		// blue  = "00a1de"
+		blue   = "00a1de"
 		// red   = "c92441"
 	)
 
@@ -112,6 +134,7 @@ func Icons() error {
 	}{
 		{"docs.png", "help.png", green},
 		{"tab.png", "tab-active.png", yellow},
+		{"folder.png", "folder-smart.png", blue},
 	}
 
 	for _, cfg := range copies {
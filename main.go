@@ -27,6 +27,7 @@ import (
 	"net/url"
 	"path/filepath"
 	"strings"
+	"time"
 
 	aw "github.com/deanishe/awgo"
 	"github.com/deanishe/awgo/update"
@@ -39,6 +40,7 @@ import (
 // Defaults for Kingpin flags
 const (
 	defaultMaxResults = "100"
+	defaultMaxOpen    = "20"
 )
 
 // Icons
@@ -57,6 +59,7 @@ var (
 	IconHome            = &aw.Icon{Value: "icons/home.png"}
 	IconIssue           = &aw.Icon{Value: "icons/issue.png"}
 	IconReadingList     = &aw.Icon{Value: "icons/reading-list.png"}
+	IconSmartFolder     = &aw.Icon{Value: "icons/folder-smart.png"}
 	IconTab             = &aw.Icon{Value: "icons/tab.png"}
 	IconUp              = &aw.Icon{Value: "icons/up.png"}
 	IconUpdateAvailable = &aw.Icon{Value: "icons/update-available.png"}
@@ -80,7 +83,14 @@ var (
 	filterActionsCmd, filterTabActionsCmd     *kingpin.CmdClause
 	filterURLActionsCmd, activeTabCmd         *kingpin.CmdClause
 	filterHistoryCmd, updateCmd, blacklistCmd *kingpin.CmdClause
-	configCmd                                 *kingpin.CmdClause
+	configCmd, importBookmarksCmd             *kingpin.CmdClause
+	filterTagsCmd, auditBookmarksCmd          *kingpin.CmdClause
+	fixBookmarkCmd, filterFavoritesCmd        *kingpin.CmdClause
+	markReadingListCmd, addReadingListCmd     *kingpin.CmdClause
+	exportReadingListCmd, pruneReadingListCmd *kingpin.CmdClause
+	nextReadingListCmd, randomCmd             *kingpin.CmdClause
+	filterHostsCmd, statsCmd, forgetCmd       *kingpin.CmdClause
+	exportHistoryCmd, timelineCmd, trailCmd   *kingpin.CmdClause
 
 	// Script options (populated by Kingpin application)
 	query                       string
@@ -98,6 +108,34 @@ var (
 	urlActionOpt, urlActionCtrl string
 	urlActionFn, urlActionShift string
 	urlActionDefault            string
+	importFile, importFolder    string
+	importPreview               bool
+	openRecursive, openForce    bool
+	openNewWindow               bool
+	maxOpen                     int
+	auditMaxDepth, auditMaxSize int
+	fixKind                     string
+	rlRead, rlUnread, rlClose   bool
+	rlSort, rlMarkState         string
+	exportFormat, exportOutput  string
+	exportNotes                 bool
+	exportHistoryFormat         string
+	exportRange, exportHost     string
+	pruneDays                   int
+	pruneBookmarked, pruneApply bool
+	randomSource                string
+	randomCount                 int
+	randomUnvisited, randomOpen bool
+	bmSort                      string
+	statsRange, statsReport     string
+	statsOutput                 string
+	forgetURL, forgetHost       string
+	forgetRange                 string
+	forgetApply                 bool
+	frecencyWeight              float64
+	frecencyHalfLife            float64
+	trailURL                    string
+	trailTime, trailContext     int
 
 	// Workflow stuff
 	wf         *aw.Workflow
@@ -160,6 +198,22 @@ func init() {
 		PlaceHolder("SCRIPT_NAME").
 		StringVar(&tabActionShift)
 
+	// Opening folders
+	app.Flag("open-recursive", "Also open bookmarks in subfolders.").
+		BoolVar(&openRecursive)
+	app.Flag("max-open", "Maximum number of bookmarks to open without confirmation.").
+		Default(defaultMaxOpen).IntVar(&maxOpen)
+
+	// Safari data
+	app.Flag("bookmarks-plist", "Path to Safari's Bookmarks.plist.").
+		PlaceHolder("PATH").
+		Default(bookmarksPlist).
+		StringVar(&bookmarksPlist)
+	app.Flag("history-db", "Path to Safari's History.db.").
+		PlaceHolder("PATH").
+		Default(historyDB).
+		StringVar(&historyDB)
+
 	// ---------------------------------------------------------------
 	// List action commands
 	filterActionsCmd = app.Command("actions", "List actions.").Alias("la")
@@ -203,25 +257,47 @@ func init() {
 	for _, cmd := range []*kingpin.CmdClause{filterFolderCmd, openCmd} {
 		cmd.Flag("uid", "Bookmark/folder UID.").Short('u').StringVar(&uid)
 	}
+	openCmd.Flag("new-window", "Open folder's bookmarks in a new window.").
+		BoolVar(&openNewWindow)
+	openCmd.Flag("force", "Open folder even if it contains more than --max-open bookmarks.").
+		BoolVar(&openForce)
 
 	// ---------------------------------------------------------------
 	// Commands using query etc.
 	searchCmd = app.Command("search", "Filter your bookmarks and recent history.").Alias("s")
-	filterBookmarksCmd = app.Command("bookmarks", "Filter your bookmarks.").Alias("b")
+	bookmarksCmd := app.Command("bookmarks", "Filter, audit and fix your bookmarks.").Alias("b")
+	filterBookmarksCmd = bookmarksCmd.Command("filter", "Filter your bookmarks.").Default()
+	auditBookmarksCmd = bookmarksCmd.Command("audit", "Find problems with your bookmarks.")
+	fixBookmarkCmd = bookmarksCmd.Command("fix", "Fix a problem found by audit.")
 	filterBookmarkletsCmd = app.Command("bookmarklets", "Filter your bookmarklets.").Alias("B")
 	filterAllFoldersCmd = app.Command("folders", "Filter your bookmark folders.").Alias("f")
-	filterReadingListCmd = app.Command("reading-list", "Filter your Reading List.").Alias("r")
+	readingListCmd := app.Command("reading-list", "Filter and manage your Reading List.").Alias("r")
+	filterReadingListCmd = readingListCmd.Command("filter", "Filter your Reading List.").Default()
+	markReadingListCmd = readingListCmd.Command("mark", "Mark a Reading List entry as read or unread.")
+	addReadingListCmd = readingListCmd.Command("add", "Add tabs to the Reading List.")
+	pruneReadingListCmd = readingListCmd.Command("prune", "Remove old and bookmarked Reading List entries.")
+	nextReadingListCmd = readingListCmd.Command("next", "Open oldest unread Reading List entry and mark it read.")
 	filterTabsCmd = app.Command("tabs", "Filter your tabs.").Alias("t")
 	filterCloudTabsCmd = app.Command("icloud", "Filter your cloud tabs.").Alias("i")
-	filterHistoryCmd = app.Command("history", "Filter your history.").Alias("h")
+	historyCmd := app.Command("history", "Filter and explore your history.").Alias("h")
+	filterHistoryCmd = historyCmd.Command("filter", "Filter your history.").Default()
+	filterHostsCmd = historyCmd.Command("hosts", "Show your history grouped by host.")
+	forgetCmd = historyCmd.Command("forget", "Delete pages from your history.")
+	timelineCmd = historyCmd.Command("timeline", "Browse your history by day.")
+	trailCmd = historyCmd.Command("trail", "Show pages visited before and after a visit.")
+	filterTagsCmd = app.Command("tags", "Filter your bookmark #tags.")
+	filterFavoritesCmd = app.Command("favorites", "List your Favorites bar by position.")
+	statsCmd = app.Command("stats", "Show browsing statistics or write a report.")
+	randomCmd = app.Command("random", "Open or list random bookmarks.")
 	configCmd = app.Command("config", "View configuration options.").Alias("c")
 
 	// Common options
 	for _, cmd := range []*kingpin.CmdClause{
-		filterBookmarksCmd, filterBookmarkletsCmd, filterFolderCmd,
-		filterAllFoldersCmd, filterReadingListCmd, filterTabsCmd,
-		filterTabActionsCmd, filterURLActionsCmd, filterHistoryCmd,
-		filterCloudTabsCmd, searchCmd, configCmd,
+		bookmarksCmd, filterBookmarkletsCmd, filterFolderCmd,
+		filterAllFoldersCmd, readingListCmd, filterTabsCmd,
+		filterTabActionsCmd, filterURLActionsCmd, historyCmd,
+		filterCloudTabsCmd, searchCmd, configCmd, filterTagsCmd,
+		filterFavoritesCmd, statsCmd,
 	} {
 		cmd.Flag("query", "Search query.").Short('q').StringVar(&query)
 		cmd.Flag("max-results", "Maximum number of results to send to Alfred.").
@@ -232,11 +308,66 @@ func init() {
 
 	// ---------------------------------------------------------------
 	// Options set via workflow configuration sheet
-	filterBookmarksCmd.Flag("include-bookmarklets", "Include bookmarklets with bookmarks.").
+	bookmarksCmd.Flag("include-bookmarklets", "Include bookmarklets with bookmarks.").
 		BoolVar(&includeBookmarklets)
+	auditBookmarksCmd.Flag("max-depth", "Report folders nested deeper than this.").
+		Default("4").IntVar(&auditMaxDepth)
+	auditBookmarksCmd.Flag("max-size", "Report folders containing more items than this.").
+		Default("50").IntVar(&auditMaxSize)
+	readingListCmd.Flag("unread", "Only show unread entries.").BoolVar(&rlUnread)
+	readingListCmd.Flag("read", "Only show read entries.").BoolVar(&rlRead)
+	readingListCmd.Flag("sort", "Sort entries by date added or date last viewed.").
+		PlaceHolder("added|viewed").EnumVar(&rlSort, "", "added", "viewed")
+	addReadingListCmd.Flag("window", "Only add tabs in this window (default: all windows).").
+		Short('w').IntVar(&winIdx)
+	addReadingListCmd.Flag("close", "Close tabs after adding them.").BoolVar(&rlClose)
+	pruneReadingListCmd.Flag("days", "Remove read entries added more than this many days ago (0 to disable).").
+		Default("30").IntVar(&pruneDays)
+	pruneReadingListCmd.Flag("bookmarked", "Remove entries whose URLs are also bookmarked.").
+		BoolVar(&pruneBookmarked)
+	pruneReadingListCmd.Flag("apply", "Remove entries instead of showing them in Alfred.").
+		BoolVar(&pruneApply)
+	markReadingListCmd.Flag("uid", "Reading List entry UID.").Short('u').Required().StringVar(&uid)
+	markReadingListCmd.Flag("state", "Whether to mark entry as read or unread.").
+		Default("read").EnumVar(&rlMarkState, "read", "unread")
+	for _, cmd := range []*kingpin.CmdClause{bookmarksCmd, filterFolderCmd} {
+		// Own envvar, as reading-list's --sort would also use ALSF_SORT
+		cmd.Flag("sort", "Sort bookmarks by number of visits or last visit, or only show those never visited.").
+			Envar("ALSF_BOOKMARK_SORT").
+			EnumVar(&bmSort, sortVisits, sortRecent, sortNever)
+	}
+	forgetCmd.Flag("url", "Delete visits to this URL.").StringVar(&forgetURL)
+	forgetCmd.Flag("host", "Delete visits to this host (and its subdomains).").StringVar(&forgetHost)
+	forgetCmd.Flag("range", "Only delete visits in this period, e.g. 1d or 2w.").StringVar(&forgetRange)
+	forgetCmd.Flag("apply", "Delete visits instead of showing them in Alfred.").BoolVar(&forgetApply)
+	trailCmd.Flag("url", "URL of visit.").StringVar(&trailURL)
+	trailCmd.Flag("time", "UNIX time of visit (default: most recent).").IntVar(&trailTime)
+	trailCmd.Flag("context", "Number of visits to show before and after.").Default("10").IntVar(&trailContext)
+	statsCmd.Flag("range", "Period to report on, e.g. 7d or 2w.").Default("7d").StringVar(&statsRange)
+	statsCmd.Flag("report", "Write a report in this format instead of showing stats in Alfred.").
+		EnumVar(&statsReport, reportMarkdown, reportHTML)
+	statsCmd.Flag("output", "File to write report to (default: STDOUT).").
+		Short('o').PlaceHolder("PATH").StringVar(&statsOutput)
+	randomCmd.Flag("source", "Where to pick from: bookmarks, reading-list or folder:UID.").
+		Default(randomSourceBookmarks).StringVar(&randomSource)
+	randomCmd.Flag("count", "Number of items to pick.").Short('n').Default("1").IntVar(&randomCount)
+	randomCmd.Flag("unvisited", "Prefer items you have never visited.").BoolVar(&randomUnvisited)
+	randomCmd.Flag("open", "Open items instead of showing them in Alfred.").BoolVar(&randomOpen)
+	fixBookmarkCmd.Flag("uid", "Bookmark/folder UID.").Short('u').Required().StringVar(&uid)
+	fixBookmarkCmd.Flag("fix", "Problem to fix.").Required().
+		EnumVar(&fixKind, findingEmpty, findingHTTP, findingBookmarklet)
+
+	for _, cmd := range []*kingpin.CmdClause{searchCmd, historyCmd} {
+		cmd.Flag("frecency-weight", "How much visit frequency and recency count in ranking results (0–1).").
+			Default("0.3").Float64Var(&frecencyWeight)
+		cmd.Flag("frecency-half-life", "Days after which a visit counts half as much in ranking.").
+			Default("14").Float64Var(&frecencyHalfLife)
+	}
 
-	searchCmd.Flag("history-entries", "Number of recent history entries to load.").
-		IntVar(&recentHistoryEntries)
+	for _, cmd := range []*kingpin.CmdClause{searchCmd, filterFolderCmd} {
+		cmd.Flag("history-entries", "Number of recent history entries to load.").
+			IntVar(&recentHistoryEntries)
+	}
 
 	// Commands that require an action
 	for _, cmd := range []*kingpin.CmdClause{
@@ -255,6 +386,38 @@ func init() {
 	blacklistCmd.Arg("scripts", "Names of scripts (without extensions).").
 		StringsVar(&scriptNames)
 
+	// ---------------------------------------------------------------
+	// Import commands
+	importCmd := app.Command("import", "Import data into Safari.")
+	importBookmarksCmd = importCmd.Command("bookmarks", "Import bookmarks from an HTML, Chrome or Firefox file.")
+	importBookmarksCmd.Arg("file", "Netscape HTML, Chrome Bookmarks or Firefox places.sqlite file.").
+		Required().ExistingFileVar(&importFile)
+	importBookmarksCmd.Flag("into", "Folder to import bookmarks into.").
+		Default("Bookmarks Menu/Imported").StringVar(&importFolder)
+	importBookmarksCmd.Flag("preview", "Show bookmarks that would be imported in Alfred.").
+		BoolVar(&importPreview)
+	importBookmarksCmd.Flag("query", "Search query.").Short('q').StringVar(&query)
+
+	// ---------------------------------------------------------------
+	// Export commands
+	exportCmd := app.Command("export", "Export data from Safari.")
+	exportReadingListCmd = exportCmd.Command("reading-list", "Export your Reading List.")
+	exportReadingListCmd.Flag("format", "Export format.").Short('f').
+		Default(formatMarkdown).
+		EnumVar(&exportFormat, formatMarkdown, formatPocket, formatInstapaper, formatJSON)
+	exportReadingListCmd.Flag("output", "File (or directory for --notes) to write to (default: STDOUT).").
+		Short('o').PlaceHolder("PATH").StringVar(&exportOutput)
+	exportReadingListCmd.Flag("notes", "Write one Markdown note per entry to --output directory.").
+		BoolVar(&exportNotes)
+	exportHistoryCmd = exportCmd.Command("history", "Export history visits.")
+	exportHistoryCmd.Flag("format", "Export format.").Short('f').
+		Default(formatCSV).
+		EnumVar(&exportHistoryFormat, formatCSV, formatJSON, formatNDJSON)
+	exportHistoryCmd.Flag("output", "File to write to (default: STDOUT).").
+		Short('o').PlaceHolder("PATH").StringVar(&exportOutput)
+	exportHistoryCmd.Flag("range", "Only export visits in this period, e.g. 2w, yesterday or \"since:2019-10-01 @9am-5pm\".").StringVar(&exportRange)
+	exportHistoryCmd.Flag("host", "Only export visits to this host (and its subdomains).").StringVar(&exportHost)
+
 	app.PreAction(func(ctx *kingpin.ParseContext) error {
 		if err := LoadScripts(scriptDirs...); err != nil {
 			return errors.Wrap(err, "load scripts")
@@ -314,6 +477,33 @@ func urlKeywords(URL string) string {
 	return h
 }
 
+// relativeTime returns a human-readable description of how long ago t was.
+func relativeTime(t time.Time) string {
+	d := time.Since(t)
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("1 %s ago", unit)
+		}
+		return fmt.Sprintf("%d %ss ago", n, unit)
+	}
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute")
+	case d < 24*time.Hour:
+		return plural(int(d/time.Hour), "hour")
+	case d < 7*24*time.Hour:
+		return plural(int(d/(24*time.Hour)), "day")
+	case d < 30*24*time.Hour:
+		return plural(int(d/(7*24*time.Hour)), "week")
+	case d < 365*24*time.Hour:
+		return plural(int(d/(30*24*time.Hour)), "month")
+	default:
+		return plural(int(d/(365*24*time.Hour)), "year")
+	}
+}
+
 // loadWindows returns a list of Safari windows and caches them for the duration of the session.
 func loadWindows() ([]*safari.Window, error) {
 
@@ -381,6 +571,11 @@ func run() {
 		wf.FatalError(err)
 	}
 
+	// Make go-safari read the same bookmarks file as the commands that
+	// write to it. go-safari's default parser is created on first use,
+	// so set its default path rather than calling safari.Configure.
+	safari.DefaultBookmarksPath = bookmarksPlist
+
 	// Create user script directories
 	util.MustExist(filepath.Join(wf.DataDir(), "scripts", "tab"))
 	util.MustExist(filepath.Join(wf.DataDir(), "scripts", "url"))
@@ -393,6 +588,12 @@ func run() {
 	case filterBookmarksCmd.FullCommand():
 		err = doFilterBookmarks()
 
+	case auditBookmarksCmd.FullCommand():
+		err = doAuditBookmarks()
+
+	case fixBookmarkCmd.FullCommand():
+		err = doFixBookmark()
+
 	case filterBookmarkletsCmd.FullCommand():
 		err = doFilterBookmarklets()
 
@@ -405,9 +606,33 @@ func run() {
 	case filterHistoryCmd.FullCommand():
 		err = doFilterHistory()
 
+	case forgetCmd.FullCommand():
+		err = doForgetHistory()
+
+	case filterHostsCmd.FullCommand():
+		err = doFilterHistoryHosts()
+
+	case timelineCmd.FullCommand():
+		err = doHistoryTimeline()
+
+	case trailCmd.FullCommand():
+		err = doHistoryTrail()
+
 	case filterReadingListCmd.FullCommand():
 		err = doFilterReadingList()
 
+	case markReadingListCmd.FullCommand():
+		err = doMarkReadingList()
+
+	case addReadingListCmd.FullCommand():
+		err = doAddToReadingList()
+
+	case pruneReadingListCmd.FullCommand():
+		err = doPruneReadingList()
+
+	case nextReadingListCmd.FullCommand():
+		err = doNextReadingList()
+
 	case filterTabsCmd.FullCommand():
 		err = doFilterTabs()
 
@@ -420,6 +645,18 @@ func run() {
 	case filterCloudTabsCmd.FullCommand():
 		err = doFilterCloudTabs()
 
+	case filterTagsCmd.FullCommand():
+		err = doFilterTags()
+
+	case filterFavoritesCmd.FullCommand():
+		err = doFilterFavorites()
+
+	case statsCmd.FullCommand():
+		err = doStats()
+
+	case randomCmd.FullCommand():
+		err = doRandom()
+
 	case searchCmd.FullCommand():
 		err = doSearch()
 
@@ -451,6 +688,15 @@ func run() {
 	case configCmd.FullCommand():
 		err = doConfig()
 
+	case exportReadingListCmd.FullCommand():
+		err = doExportReadingList()
+
+	case exportHistoryCmd.FullCommand():
+		err = doExportHistory()
+
+	case importBookmarksCmd.FullCommand():
+		err = doImportBookmarks()
+
 	default:
 		err = fmt.Errorf("unknown command: %s", cmd)
 
@@ -0,0 +1,56 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Temporary directory for the workflow's data and cache. Package vars
+// are initialised before init() in main.go creates the workflow, so
+// this provides the Alfred environment aw.New requires.
+var testDir = setTestEnv()
+
+// setTestEnv creates a temporary directory and sets Alfred's workflow
+// variables to point to it.
+func setTestEnv() string {
+	dir, err := ioutil.TempDir("", "alsf-test-")
+	if err != nil {
+		panic(err)
+	}
+	env := map[string]string{
+		"alfred_workflow_bundleid": "net.deanishe.alfred.safari",
+		"alfred_workflow_name":     "Safari Assistant",
+		"alfred_workflow_version":  "0.0.0",
+		"alfred_workflow_data":     filepath.Join(dir, "data"),
+		"alfred_workflow_cache":    filepath.Join(dir, "cache"),
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+	}
+	return dir
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	os.RemoveAll(testDir)
+	os.Exit(code)
+}
+
+// copyFile copies testdata file name to dir and returns the new path.
+func copyFile(t *testing.T, name, dir string) string {
+	t.Helper()
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,346 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"howett.net/plist"
+)
+
+// Safari's names for the top-level bookmark folders and bookmark types.
+const (
+	bookmarksBarName  = "BookmarksBar"
+	bookmarksMenuName = "BookmarksMenu"
+	readingListName   = "com.apple.ReadingList"
+
+	typeList  = "WebBookmarkTypeList"
+	typeLeaf  = "WebBookmarkTypeLeaf"
+	typeProxy = "WebBookmarkTypeProxy"
+)
+
+var (
+	// Path to Safari's bookmarks file. Overridden by --bookmarks-plist.
+	defaultBookmarksPlist = filepath.Join(os.Getenv("HOME"), "Library/Safari/Bookmarks.plist")
+	bookmarksPlist        = defaultBookmarksPlist
+	bookmarksLockState    *bool // cached by bookmarksLocked
+	// Display names of top-level folders, as shown by go-safari.
+	topLevelNames = map[string]string{
+		bookmarksBarName:  "Favorites",
+		bookmarksMenuName: "Bookmarks Menu",
+		readingListName:   "Reading List",
+	}
+)
+
+// plistNode is a bookmark or folder in the raw Bookmarks.plist tree.
+// go-safari's types are read-only and drop keys they don't understand,
+// so this is used for anything that writes to Bookmarks.plist.
+type plistNode map[string]interface{}
+
+// newLeafNode creates a new bookmark node.
+func newLeafNode(title, URL string) plistNode {
+	return plistNode{
+		"WebBookmarkType": typeLeaf,
+		"WebBookmarkUUID": newUUID(),
+		"URLString":       URL,
+		"URIDictionary":   map[string]interface{}{"title": title},
+	}
+}
+
+// newListNode creates a new, empty folder node.
+func newListNode(title string) plistNode {
+	return plistNode{
+		"WebBookmarkType": typeList,
+		"WebBookmarkUUID": newUUID(),
+		"Title":           title,
+		"Children":        []interface{}{},
+	}
+}
+
+func (n plistNode) str(key string) string {
+	s, _ := n[key].(string)
+	return s
+}
+
+// dict returns the sub-dictionary key, creating it if create is true.
+func (n plistNode) dict(key string, create bool) plistNode {
+	if m, ok := n[key].(map[string]interface{}); ok {
+		return plistNode(m)
+	}
+	if !create {
+		return nil
+	}
+	m := map[string]interface{}{}
+	n[key] = m
+	return plistNode(m)
+}
+
+// Type returns the node's WebBookmarkType.
+func (n plistNode) Type() string { return n.str("WebBookmarkType") }
+
+// IsFolder returns true if node is a folder.
+func (n plistNode) IsFolder() bool { return n.Type() == typeList }
+
+// IsBookmark returns true if node is a bookmark.
+func (n plistNode) IsBookmark() bool { return n.Type() == typeLeaf }
+
+// UID returns the node's UUID, which is what go-safari uses as its UID.
+func (n plistNode) UID() string { return n.str("WebBookmarkUUID") }
+
+// URL returns a bookmark's URL.
+func (n plistNode) URL() string { return n.str("URLString") }
+
+// Title returns the node's title. Top-level folders have their
+// internal names replaced with the ones Safari displays.
+func (n plistNode) Title() string {
+	if n.IsBookmark() {
+		if d := n.dict("URIDictionary", false); d != nil {
+			return d.str("title")
+		}
+		return ""
+	}
+	t := n.str("Title")
+	if s, ok := topLevelNames[t]; ok {
+		return s
+	}
+	return t
+}
+
+// SetTitle sets the title of a bookmark or folder.
+func (n plistNode) SetTitle(title string) {
+	if n.IsBookmark() {
+		n.dict("URIDictionary", true)["title"] = title
+		return
+	}
+	n["Title"] = title
+}
+
+// Children returns a folder's contents.
+func (n plistNode) Children() []plistNode {
+	l, _ := n["Children"].([]interface{})
+	nodes := make([]plistNode, 0, len(l))
+	for _, v := range l {
+		if m, ok := v.(map[string]interface{}); ok {
+			nodes = append(nodes, plistNode(m))
+		}
+	}
+	return nodes
+}
+
+// SetChildren replaces a folder's contents.
+func (n plistNode) SetChildren(nodes []plistNode) {
+	l := make([]interface{}, len(nodes))
+	for i, c := range nodes {
+		l[i] = map[string]interface{}(c)
+	}
+	n["Children"] = l
+}
+
+// Append adds nodes to the end of a folder.
+func (n plistNode) Append(nodes ...plistNode) {
+	n.SetChildren(append(n.Children(), nodes...))
+}
+
+// Child returns the subfolder with the given (display) title.
+func (n plistNode) Child(title string) plistNode {
+	for _, c := range n.Children() {
+		if c.IsFolder() && strings.EqualFold(c.Title(), title) {
+			return c
+		}
+	}
+	return nil
+}
+
+// Walk calls fn for node and all its descendants. path contains
+// the titles of node's ancestors.
+func (n plistNode) Walk(fn func(n plistNode, path []string)) {
+	var walk func(n plistNode, path []string)
+	walk = func(n plistNode, path []string) {
+		fn(n, path)
+		if !n.IsFolder() {
+			return
+		}
+		p := path
+		if n.Title() != "" { // root folder has no title
+			p = append(append([]string{}, path...), n.Title())
+		}
+		for _, c := range n.Children() {
+			walk(c, p)
+		}
+	}
+	walk(n, nil)
+}
+
+// bookmarksFile is a parsed Bookmarks.plist.
+type bookmarksFile struct {
+	Path   string
+	Root   plistNode
+	format int
+}
+
+// loadBookmarksFile parses the Bookmarks.plist at path.
+func loadBookmarksFile(path string) (*bookmarksFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read bookmarks")
+	}
+	var root map[string]interface{}
+	format, err := plist.Unmarshal(data, &root)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse bookmarks")
+	}
+	return &bookmarksFile{Path: path, Root: plistNode(root), format: format}, nil
+}
+
+// Folder returns the folder at path, which is a "/"-separated list of
+// folder titles, e.g. "Bookmarks Menu/Work". Internal names like
+// "BookmarksBar" are also accepted for top-level folders. If create is
+// true, missing folders are created.
+func (bf *bookmarksFile) Folder(path string, create bool) (plistNode, error) {
+	n := bf.Root
+	for _, name := range splitFolderPath(path) {
+		if s, ok := topLevelNames[name]; ok {
+			name = s
+		}
+		c := n.Child(name)
+		if c == nil {
+			if !create {
+				return nil, fmt.Errorf("no such folder: %s", path)
+			}
+			c = newListNode(name)
+			n.Append(c)
+		}
+		n = c
+	}
+	return n, nil
+}
+
+// Find returns the node with the given UID or nil.
+func (bf *bookmarksFile) Find(uid string) plistNode {
+	var found plistNode
+	bf.Root.Walk(func(n plistNode, _ []string) {
+		if found == nil && n.UID() == uid {
+			found = n
+		}
+	})
+	return found
+}
+
+// Remove deletes the node with the given UID. It returns false if no
+// such node exists.
+func (bf *bookmarksFile) Remove(uid string) bool {
+	removed := false
+	bf.Root.Walk(func(n plistNode, _ []string) {
+		if removed || !n.IsFolder() {
+			return
+		}
+		children := n.Children()
+		for i, c := range children {
+			if c.UID() == uid {
+				n.SetChildren(append(children[:i], children[i+1:]...))
+				removed = true
+				return
+			}
+		}
+	})
+	return removed
+}
+
+// bookmarksLocked returns true if bookmarksPlist is Safari's own file and
+// Safari is running, so it can't be changed. The result is cached.
+func bookmarksLocked() bool {
+	if bookmarksLockState == nil {
+		v := isLiveFile(bookmarksPlist, defaultBookmarksPlist) && safariRunning()
+		bookmarksLockState = &v
+	}
+	return *bookmarksLockState
+}
+
+// Save backs up the existing file to the workflow's data directory,
+// then atomically replaces it with the current tree.
+func (bf *bookmarksFile) Save() error {
+	if isLiveFile(bf.Path, defaultBookmarksPlist) && safariRunning() {
+		return errors.New("Quit Safari before changing bookmarks")
+	}
+	if err := backupFile(bf.Path); err != nil {
+		return errors.Wrap(err, "backup bookmarks")
+	}
+	format := bf.format
+	if format == plist.InvalidFormat {
+		format = plist.BinaryFormat
+	}
+	data, err := plist.Marshal(map[string]interface{}(bf.Root), format)
+	if err != nil {
+		return errors.Wrap(err, "encode bookmarks")
+	}
+	tmp := bf.Path + ".alsf-tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return errors.Wrap(err, "write bookmarks")
+	}
+	log.Printf("saved bookmarks to %q", bf.Path)
+	return os.Rename(tmp, bf.Path)
+}
+
+// backupFile copies path into the workflow's backup directory.
+func backupFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(wf.DataDir(), "backups")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	x := filepath.Ext(path)
+	name := fmt.Sprintf("%s-%s%s",
+		strings.TrimSuffix(filepath.Base(path), x), time.Now().Format("20060102-150405"), x)
+	log.Printf("backing up %q to %q", path, name)
+	return ioutil.WriteFile(filepath.Join(dir, name), data, 0600)
+}
+
+// isLiveFile returns true if path is Safari's own copy of the file
+// at livePath (rather than a copy elsewhere), so Safari may overwrite
+// any changes made to it while it's running.
+func isLiveFile(path, livePath string) bool {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	live, err := os.Stat(livePath)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(fi, live)
+}
+
+// splitFolderPath splits a "/"-separated folder path into its
+// non-empty components.
+func splitFolderPath(path string) []string {
+	var names []string
+	for _, s := range strings.Split(path, "/") {
+		if s = strings.TrimSpace(s); s != "" {
+			names = append(names, s)
+		}
+	}
+	return names
+}
+
+// newUUID returns a random UUID in the uppercase format Safari uses.
+func newUUID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // variant 10
+	return fmt.Sprintf("%X-%X-%X-%X-%X", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
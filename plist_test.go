@@ -0,0 +1,48 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsLiveFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alsf-plist-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		live = copyFile(t, "Bookmarks.plist", dir)
+		link = filepath.Join(dir, "link.plist")
+		cp   = filepath.Join(dir, "copy.plist")
+	)
+	if err := os.Symlink(live, link); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(live)
+	if err := ioutil.WriteFile(cp, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		x    bool
+	}{
+		{live, true},
+		{filepath.Join(dir, ".", "Bookmarks.plist"), true},
+		{link, true},
+		{cp, false},
+		{filepath.Join(dir, "missing.plist"), false},
+	}
+	for _, td := range tests {
+		if v := isLiveFile(td.path, live); v != td.x {
+			t.Errorf("isLiveFile(%q): Expected=%v, Got=%v", td.path, td.x, v)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/deanishe/go-safari/history"
+)
+
+// Source types for the type: operator.
+const (
+	sourceBookmark    = "bookmark"
+	sourceReadingList = "reading-list"
+	sourceHistory     = "history"
+)
+
+// searchQuery is a parsed query expression. Besides plain text, it may
+// contain the operators host:, in: and type:, and #tags. Operator
+// values containing spaces must be quoted, e.g. in:"Bookmarks Menu/Work".
+type searchQuery struct {
+	Text    string   // query minus operators
+	Hosts   []string // host: values
+	Folders []string // in: values (folder paths)
+	Types   []string // type: values
+	Tags    []string // #tags
+}
+
+// parseQuery parses a query expression.
+func parseQuery(s string) *searchQuery {
+	q := &searchQuery{}
+	s, q.Tags = parseTags(s)
+
+	var words []string
+	for _, tok := range tokenizeQuery(s) {
+		i := strings.Index(tok, ":")
+		if i < 1 {
+			words = append(words, tok)
+			continue
+		}
+		key, val := strings.ToLower(tok[:i]), strings.Trim(tok[i+1:], `"`)
+		switch key {
+		case "host":
+			q.Hosts = append(q.Hosts, strings.ToLower(val))
+		case "in":
+			q.Folders = append(q.Folders, val)
+		case "type":
+			q.Types = append(q.Types, strings.ToLower(val))
+		default: // not an operator, e.g. a URL
+			words = append(words, tok)
+		}
+	}
+	q.Text = strings.Join(words, " ")
+	return q
+}
+
+// tokenizeQuery splits s on whitespace. Whitespace within double
+// quotes does not split tokens.
+func tokenizeQuery(s string) []string {
+	var (
+		toks   []string
+		cur    strings.Builder
+		quoted bool
+	)
+	for _, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+			cur.WriteRune(c)
+		case !quoted && (c == ' ' || c == '\t'):
+			if cur.Len() > 0 {
+				toks = append(toks, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteRune(c)
+		}
+	}
+	if cur.Len() > 0 {
+		toks = append(toks, cur.String())
+	}
+	return toks
+}
+
+// String returns the query expression in canonical form.
+func (q *searchQuery) String() string {
+	var s []string
+	quote := func(v string) string {
+		if strings.ContainsAny(v, " \t") {
+			return `"` + v + `"`
+		}
+		return v
+	}
+	for _, v := range q.Hosts {
+		s = append(s, "host:"+quote(v))
+	}
+	for _, v := range q.Folders {
+		s = append(s, "in:"+quote(v))
+	}
+	for _, v := range q.Types {
+		s = append(s, "type:"+quote(v))
+	}
+	for _, v := range q.Tags {
+		s = append(s, "#"+v)
+	}
+	if q.Text != "" {
+		s = append(s, q.Text)
+	}
+	return strings.Join(s, " ")
+}
+
+// wantType returns true if the query includes results of type typ.
+func (q *searchQuery) wantType(typ string) bool {
+	if len(q.Types) == 0 {
+		return true
+	}
+	for _, t := range q.Types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// matchHost returns true if URL's host is (a subdomain of) one of the
+// query's hosts.
+func (q *searchQuery) matchHost(URL string) bool {
+	if len(q.Hosts) == 0 {
+		return true
+	}
+	u, err := url.Parse(URL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, h := range q.Hosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchFolders returns true if path (a bookmark's ancestors) contains
+// all of the query's folders.
+func (q *searchQuery) matchFolders(path []string) bool {
+	for _, f := range q.Folders {
+		if !inFolder(path, f) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchText returns true if each word of the query's text is contained
+// in one of fields. Case is ignored.
+func (q *searchQuery) matchText(fields ...string) bool {
+	s := strings.ToLower(strings.Join(fields, " "))
+	for _, w := range strings.Fields(strings.ToLower(q.Text)) {
+		if !strings.Contains(s, w) {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchBookmark returns true if bookmark (or Reading List entry) bm
+// matches the query's operators. Text is not checked.
+func (q *searchQuery) MatchBookmark(bm *indexBookmark) bool {
+	typ := sourceBookmark
+	if bm.ReadingList {
+		typ = sourceReadingList
+	}
+	return q.wantType(typ) &&
+		q.matchHost(bm.URL) &&
+		q.matchFolders(bm.Path) &&
+		hasTags(bm.Tags, q.Tags)
+}
+
+// MatchHistory returns true if history entry e matches the query's
+// operators. Text is not checked. Entries never match in: or #tags.
+func (q *searchQuery) MatchHistory(e *history.Entry) bool {
+	return q.wantType(sourceHistory) &&
+		len(q.Folders) == 0 && len(q.Tags) == 0 &&
+		q.matchHost(e.URL)
+}
+
+// inFolder returns true if path contains folder, which may itself be
+// a "/"-separated path. Case is ignored.
+func inFolder(path []string, folder string) bool {
+	segs := splitFolderPath(folder)
+	if len(segs) == 0 {
+		return true
+	}
+outer:
+	for i := 0; i+len(segs) <= len(path); i++ {
+		for j, s := range segs {
+			if !strings.EqualFold(path[i+j], s) {
+				continue outer
+			}
+		}
+		return true
+	}
+	return false
+}
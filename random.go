@@ -0,0 +1,147 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	aw "github.com/deanishe/awgo"
+)
+
+// Sources for the random command. Folders are specified as "folder:UID".
+const (
+	randomSourceBookmarks   = "bookmarks"
+	randomSourceReadingList = "reading-list"
+	randomSourceFolder      = "folder:"
+)
+
+// How much more likely never-visited items are to be picked with --unvisited.
+const unvisitedWeight = 4.0
+
+// doRandom opens or lists a random selection of bookmarks.
+func doRandom() error {
+
+	log.Printf("source=%q, count=%d, unvisited=%v, open=%v", randomSource, randomCount, randomUnvisited, randomOpen)
+
+	if randomOpen {
+		wf.Configure(aw.TextErrors(true))
+	}
+
+	bms, err := randomCandidates(randomSource)
+	if err != nil {
+		return err
+	}
+
+	if randomUnvisited {
+		loadVisits(bms)
+	}
+
+	bms = randomPick(bms, randomCount, randomWeight(randomUnvisited))
+	log.Printf("picked %d bookmark(s)", len(bms))
+
+	if randomOpen {
+		if len(bms) == 0 {
+			return fmt.Errorf("No bookmarks in %s", randomSource)
+		}
+		a := URLAction(urlActionDefault)
+		if a == nil {
+			return fmt.Errorf("Unknown action : %s", urlActionDefault)
+		}
+		for _, bm := range bms {
+			u, err := url.Parse(bm.URL)
+			if err != nil {
+				return err
+			}
+			log.Printf("opening \"%s\" (%s) ...", bm.Title, bm.URL)
+			if err := a.Run(u); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Show items in random order
+	wf.Configure(aw.SuppressUIDs(true))
+	for _, bm := range bms {
+		bookmarkItem(bm)
+	}
+	wf.WarnEmpty("No bookmarks found", "Try a different source?")
+	wf.SendFeedback()
+	return nil
+}
+
+// randomCandidates returns the bookmarks in source.
+func randomCandidates(source string) ([]*indexBookmark, error) {
+
+	switch {
+	case source == randomSourceBookmarks:
+		return indexBookmarks(func(bm *indexBookmark) bool {
+			return !bm.Bookmarklet && !bm.ReadingList
+		})
+
+	case source == randomSourceReadingList:
+		return indexBookmarks(func(bm *indexBookmark) bool { return bm.ReadingList })
+
+	case strings.HasPrefix(source, randomSourceFolder):
+		id := strings.TrimPrefix(source, randomSourceFolder)
+		idx, err := loadIndex()
+		if err != nil {
+			return nil, err
+		}
+		if idx.Folder(id) == nil {
+			return nil, fmt.Errorf("No folder found with UID: %s", id)
+		}
+		// Folders come before their subfolders in the index
+		uids := map[string]bool{id: true}
+		for _, f := range idx.Folders {
+			if uids[f.Parent] {
+				uids[f.UID] = true
+			}
+		}
+		return indexBookmarks(func(bm *indexBookmark) bool {
+			return uids[bm.Parent] && !bm.Bookmarklet
+		})
+	}
+
+	return nil, fmt.Errorf("Unknown source: %s", source)
+}
+
+// randomWeight returns the weight function for randomPick. If unvisited
+// is true, never-visited bookmarks are weighted higher, and loadVisits
+// must be called first.
+func randomWeight(unvisited bool) func(bm *indexBookmark) float64 {
+	return func(bm *indexBookmark) float64 {
+		if unvisited && bmVisits[bm.URL].Count == 0 {
+			return unvisitedWeight
+		}
+		return 1
+	}
+}
+
+// randomPick returns n bookmarks chosen at random. Items with a greater
+// weight are more likely to be chosen.
+func randomPick(bms []*indexBookmark, n int, weight func(bm *indexBookmark) float64) []*indexBookmark {
+
+	// Weighted sampling without replacement (Efraimidis & Spirakis):
+	// give each item the key rand^(1/weight) and take the largest keys.
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	keys := make(map[*indexBookmark]float64, len(bms))
+	for _, bm := range bms {
+		keys[bm] = math.Pow(r.Float64(), 1/weight(bm))
+	}
+
+	picked := append([]*indexBookmark{}, bms...)
+	sort.Slice(picked, func(i, j int) bool { return keys[picked[i]] > keys[picked[j]] })
+	if n > 0 && len(picked) > n {
+		picked = picked[:n]
+	}
+	return picked
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestRandomCandidates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alsf-random-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := copyFile(t, "Bookmarks.plist", dir)
+	bf, err := loadBookmarksFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	menu, err := bf.Folder("Bookmarks Menu", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	work, err := bf.Folder("Bookmarks Menu/Work", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := bf.Folder("Bookmarks Menu/Work/Sub", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	menu.Append(newLeafNode("Menu", "https://menu.com/"))
+	work.Append(newLeafNode("Work", "https://work.com/"), newLeafNode("JS", "javascript:void(0)"))
+	sub.Append(newLeafNode("Sub", "https://sub.com/"))
+	if err := bf.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		source string
+		x      []string
+	}{
+		{randomSourceBookmarks, []string{"Menu", "Sub", "The Go Programming Language", "Work"}},
+		{randomSourceReadingList, []string{"Example"}},
+		{randomSourceFolder + work.UID(), []string{"Sub", "Work"}},
+		{randomSourceFolder + sub.UID(), []string{"Sub"}},
+	}
+
+	withBookmarksPlist(path, func() {
+		for _, td := range tests {
+			bms, err := randomCandidates(td.source)
+			if err != nil {
+				t.Errorf("[%s] %v", td.source, err)
+				continue
+			}
+			var v []string
+			for _, bm := range bms {
+				v = append(v, bm.Title)
+			}
+			sort.Strings(v)
+			if !reflect.DeepEqual(v, td.x) {
+				t.Errorf("Bad candidates for %q. Expected=%q, Got=%q", td.source, td.x, v)
+			}
+		}
+
+		for _, s := range []string{"folder:NOT-A-UID", "history"} {
+			if _, err := randomCandidates(s); err == nil {
+				t.Errorf("Accepted bad source %q", s)
+			}
+		}
+	})
+}
+
+func TestRandomPick(t *testing.T) {
+	var (
+		visited   = newIndexBookmark("a", "Go", "https://golang.org/", nil)
+		unvisited = newIndexBookmark("b", "Never", "https://never.example.com/", nil)
+		bms       = []*indexBookmark{visited, unvisited}
+	)
+
+	// Count is respected and items are picked only once
+	if v := randomPick(bms, 0, randomWeight(false)); len(v) != 2 {
+		t.Errorf("Bad pick of all. Expected=2, Got=%d", len(v))
+	}
+	if v := randomPick(bms, 5, randomWeight(false)); len(v) != 2 || v[0] == v[1] {
+		t.Errorf("Bad pick of 5: %v", v)
+	}
+	if v := randomPick(bms, 1, randomWeight(false)); len(v) != 1 {
+		t.Errorf("Bad pick of 1. Expected=1, Got=%d", len(v))
+	}
+
+	withHistoryDB(t, func(_ string) {
+		prev := bmVisits
+		defer func() { bmVisits = prev }()
+		loadVisits(bms)
+		if bmVisits[visited.URL].Count == 0 {
+			t.Fatalf("no visits for %s", visited.URL)
+		}
+
+		// Never-visited items are unvisitedWeight times as likely
+		// to be picked first: 4/5 of the time for two items.
+		tests := []struct {
+			unvisited bool
+			min, max  float64
+		}{
+			{false, 0.45, 0.55},
+			{true, 0.75, 0.85},
+		}
+		for _, td := range tests {
+			var (
+				n    = 4000
+				hits int
+			)
+			for i := 0; i < n; i++ {
+				if randomPick(bms, 1, randomWeight(td.unvisited))[0] == unvisited {
+					hits++
+				}
+			}
+			if r := float64(hits) / float64(n); r < td.min || r > td.max {
+				t.Errorf("Bad share of unvisited (unvisited=%v). Expected=%.2f-%.2f, Got=%.3f",
+					td.unvisited, td.min, td.max, r)
+			}
+		}
+	})
+}
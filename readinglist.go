@@ -0,0 +1,583 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	aw "github.com/deanishe/awgo"
+	safari "github.com/deanishe/go-safari"
+)
+
+// Maximum length of previews fetched from web pages.
+const maxPreviewLength = 300
+
+// Name of file in data directory of entries opened by "reading-list
+// next" while Safari was running, which are still to be marked as read.
+const pendingReadName = "reading-list-pending.json"
+
+// Limits for fetching previews: the number of pages fetched at once and
+// the time allowed for fetching all of them.
+const (
+	maxPreviewFetches = 8
+	previewTimeout    = 10 * time.Second
+)
+
+var (
+	metaDescriptionRx = regexp.MustCompile(`(?is)<meta\s+(?:name|property)="(?:og:)?description"\s+content="([^"]*)"`)
+	scriptRx          = regexp.MustCompile(`(?is)<(script|style)[^>]*>.*?</(script|style)>`)
+	htmlTagRx         = regexp.MustCompile(`(?s)<[^>]+>`)
+)
+
+// Filter Safari's Reading List and sends results to Alfred.
+func doFilterReadingList() error {
+
+	log.Printf("unread=%v, read=%v, sort=%q", rlUnread, rlRead, rlSort)
+
+	bms, err := indexBookmarks(func(bm *indexBookmark) bool {
+		if !bm.ReadingList {
+			return false
+		}
+		if rlUnread && bm.Read() || rlRead && !bm.Read() {
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	switch rlSort {
+	case "added": // newest first
+		sort.SliceStable(bms, func(i, j int) bool { return bms[i].DateAdded.After(bms[j].DateAdded) })
+	case "viewed": // most recently read first, unread last
+		sort.SliceStable(bms, func(i, j int) bool { return bms[i].DateLastViewed.After(bms[j].DateLastViewed) })
+	}
+
+	// Keep Alfred from re-ordering sorted entries
+	if rlSort != "" {
+		wf.Configure(aw.SuppressUIDs(true))
+	}
+
+	return filterBookmarks(bms, rlSort != "")
+}
+
+// doMarkReadingList marks a Reading List entry as read or unread.
+func doMarkReadingList() error {
+
+	wf.Configure(aw.TextErrors(true))
+
+	log.Printf("uid=%s, state=%s", uid, rlMarkState)
+
+	bf, err := loadBookmarksFile(bookmarksPlist)
+	if err != nil {
+		return err
+	}
+
+	n := bf.Find(uid)
+	if n == nil || n.dict("ReadingList", false) == nil {
+		return fmt.Errorf("Not a Reading List entry: %s", uid)
+	}
+	setRead(n, rlMarkState == "read")
+
+	if err := bf.Save(); err != nil {
+		return err
+	}
+	fmt.Printf("Marked \"%s\" as %s\n", n.Title(), rlMarkState)
+	return nil
+}
+
+// setRead marks Reading List node n as read or unread. Safari considers
+// an entry read if it has a DateLastViewed.
+func setRead(n plistNode, read bool) {
+	rl := n.dict("ReadingList", true)
+	if read {
+		rl["DateLastViewed"] = time.Now()
+	} else {
+		delete(rl, "DateLastViewed")
+	}
+}
+
+// readingListSubtitle returns a subtitle for a Reading List entry with
+// relative dates added and read.
+func readingListSubtitle(bm *indexBookmark) string {
+	var s []string
+	if !bm.DateAdded.IsZero() {
+		s = append(s, "Added "+relativeTime(bm.DateAdded))
+	}
+	if bm.Read() {
+		s = append(s, "Read "+relativeTime(bm.DateLastViewed))
+	} else {
+		s = append(s, "Unread")
+	}
+	return strings.Join(append(s, bm.URL), " · ")
+}
+
+// markReadModifier adds a ⌘⌥↩ "Mark as Read/Unread" action to Item.
+func markReadModifier(it *aw.Item, bm *indexBookmark) {
+	m := it.NewModifier("cmd", "alt").
+		Valid(true).
+		Icon(IconReadingList).
+		Var("ALSF_UID", bm.UID).
+		Var("action", "mark-read")
+
+	if bm.Read() {
+		m.Subtitle("Mark as Unread").Var("ALSF_STATE", "unread")
+	} else {
+		m.Subtitle("Mark as Read").Var("ALSF_STATE", "read")
+	}
+	if bookmarksLocked() {
+		m.Subtitle("Quit Safari to mark entries as read or unread").Valid(false)
+	}
+}
+
+// doAddToReadingList adds Safari tabs to the Reading List. Tabs are
+// selected by window (all windows if winIdx is 0) and query.
+func doAddToReadingList() error {
+
+	wf.Configure(aw.TextErrors(true))
+
+	log.Printf("window=%d, query=%q, close=%v", winIdx, query, rlClose)
+
+	wins, err := loadWindows()
+	if err != nil {
+		return err
+	}
+
+	var (
+		sq   = parseQuery(query)
+		tabs []*safari.Tab
+	)
+	for _, w := range wins {
+		if winIdx != 0 && w.Index != winIdx {
+			continue
+		}
+		for _, t := range w.Tabs {
+			if isWebURL(t.URL) && sq.matchHost(t.URL) && sq.matchText(t.Title, t.URL) {
+				tabs = append(tabs, t)
+			}
+		}
+	}
+	if len(tabs) == 0 {
+		return errors.New("No matching tabs")
+	}
+
+	n, err := addTabsToReadingList(tabs, rlClose)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Added %d tab(s) to Reading List\n", n)
+	return nil
+}
+
+// addTabsToReadingList adds tabs to the Reading List, fetching previews
+// from the web. Tabs whose URLs are already in the Reading List are
+// skipped. If close is true, the added tabs are closed afterwards. It
+// returns the number of entries added.
+func addTabsToReadingList(tabs []*safari.Tab, close bool) (int, error) {
+
+	bf, err := loadBookmarksFile(bookmarksPlist)
+	if err != nil {
+		return 0, err
+	}
+	rl, err := bf.Folder(readingListName, true)
+	if err != nil {
+		return 0, err
+	}
+
+	seen := map[string]bool{}
+	for _, n := range rl.Children() {
+		seen[normaliseURL(n.URL())] = true
+	}
+
+	var added []*safari.Tab
+	for _, t := range tabs {
+		k := normaliseURL(t.URL)
+		if seen[k] {
+			log.Printf("already in Reading List: %s", t.URL)
+			continue
+		}
+		seen[k] = true
+		added = append(added, t)
+	}
+
+	if len(added) > 0 {
+		urls := make([]string, len(added))
+		for i, t := range added {
+			urls[i] = t.URL
+		}
+		previews := fetchPreviews(urls, previewTimeout)
+
+		nodes := make([]plistNode, len(added))
+		for i, t := range added {
+			nodes[i] = newReadingListNode(t.Title, t.URL, previews[i])
+		}
+		if err := addReadingListNodes(bf, rl, nodes); err != nil {
+			return 0, err
+		}
+	}
+
+	if close {
+		// Only close tabs that were added, not duplicates. Close them
+		// from last to first, so indices stay valid.
+		sort.Slice(added, func(i, j int) bool {
+			if added[i].WindowIndex != added[j].WindowIndex {
+				return added[i].WindowIndex > added[j].WindowIndex
+			}
+			return added[i].Index > added[j].Index
+		})
+		for _, t := range added {
+			log.Printf("closing tab %dx%d ...", t.WindowIndex, t.Index)
+			if err := safari.CloseTab(t.WindowIndex, t.Index); err != nil {
+				return len(added), err
+			}
+		}
+	}
+
+	return len(added), nil
+}
+
+// addReadingListNodes adds entries to the top of Reading List folder rl.
+// Safari overwrites changes made to its own Bookmarks.plist while it's
+// running, so entries are added via Safari if bf is that file. Other
+// files (i.e. --bookmarks-plist) are written directly.
+func addReadingListNodes(bf *bookmarksFile, rl plistNode, nodes []plistNode) error {
+
+	if !isLiveFile(bf.Path, defaultBookmarksPlist) {
+		// Safari shows newest entries first
+		rl.SetChildren(append(nodes, rl.Children()...))
+		return bf.Save()
+	}
+
+	script := `function run(argv) {
+	var safari = Application('Safari');
+	for (var i = 0; i < argv.length; i += 3) {
+		var opts = {withTitle: argv[i+1]};
+		if (argv[i+2]) opts.andPreviewText = argv[i+2];
+		safari.addReadingListItem(argv[i], opts);
+	}
+}`
+	args := []string{"-l", "JavaScript", "-e", script}
+	// Each entry is added to the top, so add the last one first
+	for i := len(nodes) - 1; i >= 0; i-- {
+		n := nodes[i]
+		args = append(args, n.URL(), n.Title(), n.dict("ReadingList", true).str("PreviewText"))
+	}
+	cmd := exec.Command("/usr/bin/osascript", args...)
+	log.Printf("adding %d entries to Reading List via Safari ...", len(nodes))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("add to Reading List: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// newReadingListNode creates a new, unread Reading List entry.
+func newReadingListNode(title, URL, preview string) plistNode {
+	n := newLeafNode(title, URL)
+	rl := map[string]interface{}{"DateAdded": time.Now()}
+	if preview != "" {
+		rl["PreviewText"] = preview
+	}
+	n["ReadingList"] = rl
+	return n
+}
+
+// fetchPreviews fetches previews for URLs in parallel. Previews for
+// pages that haven't been fetched by the time timeout expires are empty.
+func fetchPreviews(urls []string, timeout time.Duration) []string {
+
+	var (
+		start       = time.Now()
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+		previews    = make([]string, len(urls))
+		sem         = make(chan struct{}, maxPreviewFetches)
+		wg          sync.WaitGroup
+	)
+	defer cancel()
+
+	for i, URL := range urls {
+		wg.Add(1)
+		go func(i int, URL string) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				return
+			}
+			previews[i] = fetchPreview(ctx, URL)
+		}(i, URL)
+	}
+	wg.Wait()
+
+	log.Printf("fetched %d preview(s) in %v", len(urls), time.Since(start))
+	return previews
+}
+
+// fetchPreview returns a short description of the page at URL, taken
+// from its description meta tag or its text. It returns an empty string
+// if the page can't be fetched.
+func fetchPreview(ctx context.Context, URL string) string {
+
+	req, err := http.NewRequest("GET", URL, nil)
+	if err != nil {
+		log.Printf("couldn't fetch %s: %v", URL, err)
+		return ""
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	r, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		log.Printf("couldn't fetch %s: %v", URL, err)
+		return ""
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		log.Printf("couldn't fetch %s: %s", URL, r.Status)
+		return ""
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, 512*1024))
+	if err != nil {
+		return ""
+	}
+	page := string(data)
+
+	if m := metaDescriptionRx.FindStringSubmatch(page); m != nil {
+		return html.UnescapeString(strings.TrimSpace(m[1]))
+	}
+
+	// Fall back to page text
+	if i := strings.Index(strings.ToLower(page), "<body"); i >= 0 {
+		page = page[i:]
+	}
+	page = scriptRx.ReplaceAllString(page, " ")
+	page = html.UnescapeString(htmlTagRx.ReplaceAllString(page, " "))
+	text := strings.Join(strings.Fields(page), " ")
+	if r := []rune(text); len(r) > maxPreviewLength {
+		text = string(r[:maxPreviewLength]) + "…"
+	}
+	return text
+}
+
+// addToReadingList is a tab action that adds a tab to the Reading List.
+type addToReadingList struct {
+	close bool // close tab afterwards
+}
+
+// Implement Actionable.
+func (a *addToReadingList) Icon() *aw.Icon { return IconReadingList }
+func (a *addToReadingList) Title() string {
+	if a.close {
+		return "Move to Reading List"
+	}
+	return "Add to Reading List"
+}
+func (a *addToReadingList) Run(t *safari.Tab) error {
+	if !isWebURL(t.URL) {
+		return fmt.Errorf("Can't add to Reading List: %s", t.URL)
+	}
+	_, err := addTabsToReadingList([]*safari.Tab{t}, a.close)
+	return err
+}
+
+// pruneCandidates returns Reading List entries that are read and were
+// added more than days ago, or whose URLs are also bookmarked (if
+// bookmarked is true). An age of 0 disables the age check.
+func pruneCandidates(days int, bookmarked bool) ([]*indexBookmark, error) {
+
+	idx, err := loadIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		cutoff = time.Now().AddDate(0, 0, -days)
+		saved  = map[string]bool{}
+		bms    []*indexBookmark
+	)
+	if bookmarked {
+		for _, bm := range idx.Bookmarks {
+			if !bm.ReadingList {
+				saved[normaliseURL(bm.URL)] = true
+			}
+		}
+	}
+
+	for _, bm := range idx.Bookmarks {
+		if !bm.ReadingList {
+			continue
+		}
+		if (days > 0 && bm.Read() && bm.DateAdded.Before(cutoff)) ||
+			saved[normaliseURL(bm.URL)] {
+			bms = append(bms, bm)
+		}
+	}
+	return bms, nil
+}
+
+// doPruneReadingList removes old, read Reading List entries and those
+// that are also bookmarked. Without --apply, the entries that would be
+// removed are shown in Alfred.
+func doPruneReadingList() error {
+
+	log.Printf("days=%d, bookmarked=%v, apply=%v", pruneDays, pruneBookmarked, pruneApply)
+
+	if pruneApply {
+		wf.Configure(aw.TextErrors(true))
+	}
+
+	bms, err := pruneCandidates(pruneDays, pruneBookmarked)
+	if err != nil {
+		return err
+	}
+	log.Printf("%d entries to prune", len(bms))
+
+	if !pruneApply {
+		return previewPrune(bms)
+	}
+
+	if len(bms) == 0 {
+		fmt.Println("Nothing to prune")
+		return nil
+	}
+
+	bf, err := loadBookmarksFile(bookmarksPlist)
+	if err != nil {
+		return err
+	}
+	n := 0
+	for _, bm := range bms {
+		if bf.Remove(bm.UID) {
+			n++
+		}
+	}
+	if err := bf.Save(); err != nil {
+		return err
+	}
+	fmt.Printf("Removed %d entries from Reading List\n", n)
+	return nil
+}
+
+// previewPrune shows the entries that prune would remove.
+func previewPrune(bms []*indexBookmark) error {
+
+	if query == "" && len(bms) > 0 {
+		wf.Configure(aw.SuppressUIDs(true))
+		it := wf.NewItem(fmt.Sprintf("Remove %d Entries from Reading List", len(bms))).
+			Subtitle("Bookmarks.plist will be backed up first").
+			Icon(IconWarning).
+			Valid(true).
+			Var("ALSF_DAYS", strconv.Itoa(pruneDays)).
+			Var("ALSF_APPLY", "1").
+			Var("action", "prune")
+
+		if pruneBookmarked {
+			it.Var("ALSF_BOOKMARKED", "1")
+		}
+	}
+
+	for _, bm := range bms {
+		bookmarkItem(bm)
+	}
+
+	if query != "" {
+		res := wf.Filter(query)
+		log.Printf("%d entries for %q", len(res), query)
+	}
+
+	wf.WarnEmpty("Nothing to prune", "Your Reading List is tidy")
+	wf.SendFeedback()
+	return nil
+}
+
+// doNextReadingList opens the oldest unread Reading List entry and
+// marks it as read. Safari overwrites changes to its bookmarks while it's
+// running, so entries opened then are recorded in the workflow's data
+// directory instead. They are skipped, and marked as read when Safari
+// isn't running.
+func doNextReadingList() error {
+
+	wf.Configure(aw.TextErrors(true))
+
+	var pending []string
+	if wf.Data.Exists(pendingReadName) {
+		if err := wf.Data.LoadJSON(pendingReadName, &pending); err != nil {
+			return err
+		}
+	}
+	skip := map[string]bool{}
+	for _, uid := range pending {
+		skip[uid] = true
+	}
+
+	bms, err := indexBookmarks(func(bm *indexBookmark) bool {
+		return bm.ReadingList && !bm.Read() && !skip[bm.UID]
+	})
+	if err != nil {
+		return err
+	}
+	if len(bms) == 0 {
+		return errors.New("No unread entries")
+	}
+	sort.SliceStable(bms, func(i, j int) bool { return bms[i].DateAdded.Before(bms[j].DateAdded) })
+	bm := bms[0]
+
+	a := URLAction(urlActionDefault)
+	if a == nil {
+		return fmt.Errorf("Unknown action : %s", urlActionDefault)
+	}
+	u, err := url.Parse(bm.URL)
+	if err != nil {
+		return err
+	}
+
+	// Mark as read before opening, as that may launch Safari
+	pending = append(pending, bm.UID)
+	if bookmarksLocked() {
+		log.Printf("Safari is running: marking %d entries as read later", len(pending))
+		if err := wf.Data.StoreJSON(pendingReadName, pending); err != nil {
+			return err
+		}
+	} else {
+		if err := markRead(pending); err != nil {
+			return err
+		}
+		if err := wf.Data.Store(pendingReadName, nil); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("opening \"%s\" (%s) ...", bm.Title, bm.URL)
+	return a.Run(u)
+}
+
+// markRead marks the Reading List entries with the given UIDs as read.
+// Entries that no longer exist are ignored.
+func markRead(uids []string) error {
+	bf, err := loadBookmarksFile(bookmarksPlist)
+	if err != nil {
+		return err
+	}
+	for _, uid := range uids {
+		if n := bf.Find(uid); n != nil && n.dict("ReadingList", false) != nil {
+			setRead(n, true)
+		}
+	}
+	return bf.Save()
+}
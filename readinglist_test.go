@@ -0,0 +1,115 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	safari "github.com/deanishe/go-safari"
+)
+
+func TestAddTabsToReadingList(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/meta":
+			fmt.Fprint(w, `<html><head><meta name="description" content="Fish &amp; chips"></head></html>`)
+		case "/text":
+			fmt.Fprint(w, `<html><body><script>var x;</script><p>Some   text</p></body></html>`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "alsf-readinglist-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	prev := bookmarksPlist
+	bookmarksPlist = copyFile(t, "Bookmarks.plist", dir)
+	defer func() { bookmarksPlist = prev }()
+
+	tabs := []*safari.Tab{
+		{Title: "Meta", URL: srv.URL + "/meta"},
+		{Title: "Text", URL: srv.URL + "/text"},
+		{Title: "Missing", URL: srv.URL + "/missing"},
+		{Title: "Example", URL: "https://example.com"}, // already in Reading List
+	}
+	n, err := addTabsToReadingList(tabs, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("Bad count. Expected=3, Got=%d", n)
+	}
+
+	bf, err := loadBookmarksFile(bookmarksPlist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl, err := bf.Folder(readingListName, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []string
+	for _, n := range rl.Children() {
+		entries = append(entries, fmt.Sprintf("%s|%s|%s", n.Title(), n.URL(),
+			n.dict("ReadingList", false).str("PreviewText")))
+	}
+	x := []string{
+		"Meta|" + srv.URL + "/meta|Fish & chips",
+		"Text|" + srv.URL + "/text|Some text",
+		"Missing|" + srv.URL + "/missing|",
+		"Example|https://example.com/|",
+	}
+	if !reflect.DeepEqual(entries, x) {
+		t.Errorf("Bad Reading List. Expected=%q, Got=%q", x, entries)
+	}
+
+	// Adding the same tabs again does nothing
+	if n, err = addTabsToReadingList(tabs, false); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("Re-adding added %d", n)
+	}
+}
+
+func TestMarkRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alsf-readinglist-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	prev := bookmarksPlist
+	bookmarksPlist = copyFile(t, "Bookmarks.plist", dir)
+	defer func() { bookmarksPlist = prev }()
+
+	// Unknown UIDs are ignored
+	uid := "9F4D3A2B-1C0E-4B8A-9D7F-6E5C4B3A2F04"
+	if err := markRead([]string{uid, "NOT-A-UID"}); err != nil {
+		t.Fatal(err)
+	}
+
+	bf, err := loadBookmarksFile(bookmarksPlist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := bf.Find(uid)
+	if n == nil {
+		t.Fatalf("entry %s not found", uid)
+	}
+	if _, ok := n.dict("ReadingList", false)["DateLastViewed"]; !ok {
+		t.Errorf("entry not marked as read")
+	}
+}
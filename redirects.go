@@ -0,0 +1,266 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	aw "github.com/deanishe/awgo"
+	"github.com/deanishe/go-safari/history"
+	"github.com/pkg/errors"
+)
+
+// redirectTarget is the page at the end of a chain of redirects.
+type redirectTarget struct {
+	URL   string
+	Title string
+	Time  time.Time
+}
+
+// redirectTargets returns the final destinations of URLs whose most
+// recent visit was redirected (e.g. URL shorteners, tracking links or
+// SSO bounces). URLs that weren't redirected are not in the map.
+func redirectTargets(urls []string) (map[string]*redirectTarget, error) {
+
+	start := time.Now()
+	db, err := openSQLite(historyDB, true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	targets := map[string]*redirectTarget{}
+	for i := 0; i < len(urls); i += maxSQLParams {
+		batch := urls[i:]
+		if len(batch) > maxSQLParams {
+			batch = batch[:maxSQLParams]
+		}
+
+		args := make([]interface{}, len(batch))
+		for j, u := range batch {
+			args[j] = u
+		}
+		// Follow redirect_destination from each URL's latest visit until
+		// a visit that wasn't redirected. UNION drops cycles.
+		q := `
+			WITH RECURSIVE chain(start, visit) AS (
+				SELECT i.url, v.redirect_destination
+				FROM history_visits v JOIN history_items i ON v.history_item = i.id
+				WHERE i.url IN (?` + strings.Repeat(",?", len(batch)-1) + `)
+					AND v.redirect_destination IS NOT NULL
+					AND v.visit_time = (SELECT MAX(visit_time) FROM history_visits WHERE history_item = i.id)
+				UNION
+				SELECT c.start, v.redirect_destination
+				FROM chain c JOIN history_visits v ON v.id = c.visit
+				WHERE v.redirect_destination IS NOT NULL
+			)
+			SELECT c.start, i.url, IFNULL(v.title, ''), v.visit_time
+			FROM chain c
+			JOIN history_visits v ON v.id = c.visit
+			JOIN history_items i ON v.history_item = i.id
+			WHERE v.redirect_destination IS NULL`
+
+		rows, err := db.Query(q, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var (
+				from string
+				t    = &redirectTarget{}
+				ts   float64
+			)
+			if err := rows.Scan(&from, &t.URL, &t.Title, &ts); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			t.Time = fromCoreData(ts)
+			if prev, ok := targets[from]; !ok || t.Time.After(prev.Time) {
+				targets[from] = t
+			}
+		}
+		if err := rows.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Printf("[history] %d/%d URL(s) redirected in %v", len(targets), len(urls), time.Since(start))
+	return targets, nil
+}
+
+// collapseRedirects replaces entries that were redirected with the
+// final destination of the redirect chain. Entries' original URLs are
+// returned in via.
+func collapseRedirects(entries []*history.Entry) (via map[*history.Entry]string) {
+
+	via = map[*history.Entry]string{}
+	urls := make([]string, len(entries))
+	for i, e := range entries {
+		urls[i] = e.URL
+	}
+	targets, err := redirectTargets(urls)
+	if err != nil {
+		log.Printf("[history] couldn't load redirects: %v", err)
+		return
+	}
+
+	for i, e := range entries {
+		t, ok := targets[e.URL]
+		if !ok || t.URL == e.URL {
+			continue
+		}
+		ne := &history.Entry{URL: t.URL, Title: t.Title, Time: t.Time}
+		if ne.Title == "" {
+			ne.Title = e.Title
+		}
+		via[ne] = e.URL
+		entries[i] = ne
+	}
+	return
+}
+
+// visitTrail returns the visit to URL nearest to time at (or the most
+// recent one if at is zero) and the n visits before and after it,
+// oldest first. The index of the visit to URL is also returned.
+func visitTrail(URL string, at time.Time, n int) ([]*historyVisit, int, error) {
+
+	db, err := openSQLite(historyDB, true)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer db.Close()
+
+	var center []*historyVisit
+	if at.IsZero() {
+		center, err = queryVisits(db, "WHERE i.url = ? ORDER BY v.visit_time DESC LIMIT 1", URL)
+	} else {
+		center, err = queryVisits(db, "WHERE i.url = ? ORDER BY ABS(v.visit_time - ?) LIMIT 1", URL, toCoreData(at))
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(center) == 0 {
+		return nil, 0, nil
+	}
+
+	var (
+		hv = center[0]
+		ts = toCoreData(hv.Time)
+	)
+	before, err := queryVisits(db, `
+		WHERE v.visit_time < ? OR (v.visit_time = ? AND v.id < ?)
+		ORDER BY v.visit_time DESC, v.id DESC LIMIT ?`, ts, ts, hv.ID, n)
+	if err != nil {
+		return nil, 0, err
+	}
+	after, err := queryVisits(db, `
+		WHERE v.visit_time > ? OR (v.visit_time = ? AND v.id > ?)
+		ORDER BY v.visit_time, v.id LIMIT ?`, ts, ts, hv.ID, n)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	trail := make([]*historyVisit, 0, len(before)+len(after)+1)
+	for i := len(before) - 1; i >= 0; i-- {
+		trail = append(trail, before[i])
+	}
+	trail = append(trail, hv)
+	trail = append(trail, after...)
+	return trail, len(before), nil
+}
+
+// doHistoryTrail shows the pages visited immediately before and after
+// a visit to a URL.
+func doHistoryTrail() error {
+
+	if trailURL == "" {
+		return errors.New("No URL specified")
+	}
+
+	var at time.Time
+	if trailTime > 0 {
+		at = time.Unix(int64(trailTime), 0)
+	}
+	log.Printf("url=%q, time=%v, context=%d", trailURL, at, trailContext)
+
+	trail, idx, err := visitTrail(trailURL, at, trailContext)
+	if err != nil {
+		return err
+	}
+
+	// Keep Alfred from re-ordering items based on usage
+	wf.Configure(aw.SuppressUIDs(true))
+
+	for i, hv := range trail {
+		var (
+			e   = &history.Entry{URL: hv.URL, Title: hv.Title, Time: hv.Time}
+			sub = hv.Time.Format("15:04")
+		)
+		switch {
+		case i == idx:
+			sub = "This visit · " + visitTime(hv.Time)
+		case !sameDay(hv.Time, trail[idx].Time):
+			sub = visitTime(hv.Time)
+		}
+		if hv.RedirectTo != "" {
+			sub += " · Redirected"
+		}
+		it := historyItem(e).Subtitle(sub + " · " + hv.URL)
+		if i == idx {
+			it.Icon(IconActive)
+		}
+	}
+
+	if query != "" {
+		res := wf.Filter(query)
+		log.Printf("%d result(s) for %q", len(res), query)
+	}
+
+	wf.WarnEmpty("No visits found", "Try a different URL?")
+	wf.SendFeedback()
+	return nil
+}
+
+// originalURLModifier adds a ⌘⇧↩ "Open original URL" action to the Item
+// of an entry collapsed from a redirect.
+func originalURLModifier(it *aw.Item, via string) {
+	it.NewModifier("cmd", "shift").
+		Subtitle("Open original URL: "+via).
+		Arg(via).
+		Valid(true).
+		Var("ALSF_URL", via).
+		Var("ALSF_UID", via).
+		Var("ALSF_ACTION", urlActionDefault).
+		Var("action", "open")
+}
+
+// trailModifier adds a ⌃⌥↩ "Show trail" action to Item.
+func trailModifier(it *aw.Item, e *history.Entry) {
+	m := it.NewModifier("ctrl", "alt").
+		Subtitle("Show trail (pages visited before & after)").
+		Arg("").
+		Valid(true).
+		Var("ALSF_URL", e.URL).
+		Var("action", "trail")
+	if !e.Time.IsZero() {
+		m.Var("ALSF_TIME", strconv.FormatInt(e.Time.Unix(), 10))
+	}
+}
+
+// sameDay returns true if a and b are on the same (local) day.
+func sameDay(a, b time.Time) bool {
+	return startOfDay(a).Equal(startOfDay(b))
+}
+
+// viaHost returns " (via <host>)" for a collapsed redirect or "".
+func viaHost(via string) string {
+	if u, err := url.Parse(via); err == nil && u.Hostname() != "" {
+		return " (via " + u.Hostname() + ")"
+	}
+	return ""
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"testing"
+
+	"github.com/deanishe/go-safari/history"
+)
+
+func TestRedirectTargets(t *testing.T) {
+	withHistoryDB(t, func(path string) {
+		urls := []string{"https://t.co/abc123", "https://golang.org/", "https://www.example.com/", "https://nothere.net/"}
+
+		// Visit 5 (t.co) redirected to visit 6 (www.example.com)
+		targets, err := redirectTargets(urls)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(targets) != 1 {
+			t.Errorf("Bad targets. Expected=1, Got=%d", len(targets))
+		}
+		x := redirectTarget{URL: "https://www.example.com/", Title: "Example Domain", Time: fromCoreData(590200001)}
+		if v := targets["https://t.co/abc123"]; v == nil || v.URL != x.URL || v.Title != x.Title || !v.Time.Equal(x.Time) {
+			t.Errorf("Bad target for t.co. Expected=%+v, Got=%+v", x, v)
+		}
+
+		// Chains are followed to the end and cycles are ignored
+		db, err := openSQLite(path, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		_, err = db.Exec(`
+			INSERT INTO history_items (id, url, domain_expansion, visit_count) VALUES
+				(6, 'https://bit.ly/x', 'bit', 1),
+				(7, 'https://loop.example.com/a', 'loop', 1),
+				(8, 'https://loop.example.com/b', 'loop', 1);
+			INSERT INTO history_visits (id, history_item, visit_time, title, redirect_source, redirect_destination) VALUES
+				(10, 6, 590400000.0, NULL, NULL, 11),
+				(11, 3, 590400001.0, NULL, 10, 12),
+				(12, 5, 590400002.0, 'Example Page', 11, NULL),
+				(13, 7, 590500000.0, NULL, 14, 14),
+				(14, 8, 590500001.0, NULL, 13, 13);`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		urls = []string{"https://bit.ly/x", "https://t.co/abc123", "https://loop.example.com/a"}
+		if targets, err = redirectTargets(urls); err != nil {
+			t.Fatal(err)
+		}
+		tests := []struct {
+			from, to string
+		}{
+			{"https://bit.ly/x", "https://example.com/page"},
+			// Only the most recent visit counts
+			{"https://t.co/abc123", "https://example.com/page"},
+		}
+		for _, td := range tests {
+			if v := targets[td.from]; v == nil || v.URL != td.to {
+				t.Errorf("Bad target for %q. Expected=%q, Got=%+v", td.from, td.to, v)
+			}
+		}
+		if v, ok := targets["https://loop.example.com/a"]; ok {
+			t.Errorf("Redirect loop has target: %+v", v)
+		}
+	})
+}
+
+func TestCollapseRedirects(t *testing.T) {
+	withHistoryDB(t, func(_ string) {
+		entries := []*history.Entry{
+			{URL: "https://golang.org/", Title: "The Go Programming Language"},
+			{URL: "https://t.co/abc123", Title: "t.co"},
+		}
+		orig := entries[1]
+		via := collapseRedirects(entries)
+
+		if entries[0].URL != "https://golang.org/" {
+			t.Errorf("Unredirected entry changed: %q", entries[0].URL)
+		}
+		e := entries[1]
+		if e == orig || e.URL != "https://www.example.com/" || e.Title != "Example Domain" {
+			t.Errorf("Bad redirected entry: %+v", e)
+		}
+		if len(via) != 1 || via[e] != "https://t.co/abc123" {
+			t.Errorf("Bad via. Expected=%q, Got=%v", "https://t.co/abc123", via)
+		}
+	})
+}
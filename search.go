@@ -12,7 +12,7 @@ import (
 	"log"
 	"time"
 
-	"github.com/deanishe/go-safari"
+	aw "github.com/deanishe/awgo"
 	"github.com/deanishe/go-safari/history"
 )
 
@@ -22,65 +22,56 @@ func doSearch() error {
 	showUpdateStatus()
 
 	var (
-		bms     []*safari.Bookmark
+		bms     []*indexBookmark
 		entries []*history.Entry
 		start   time.Time
+		err     error
 	)
 
+	sq := parseQuery(query)
+	q := sq.Text
+	log.Printf("query=%q, operators=%q", q, sq)
+
 	start = time.Now()
-	bms = safari.FilterBookmarks(func(bm *safari.Bookmark) bool {
-		return !bm.IsBookmarklet()
+	bms, err = indexBookmarks(func(bm *indexBookmark) bool {
+		return !bm.Bookmarklet && !bm.ReadingList
 	})
+	if err != nil {
+		return err
+	}
+	bms = filterQuery(bms, sq)
 
 	log.Printf("loaded %d bookmarks in %v", len(bms), time.Now().Sub(start))
 
 	start = time.Now()
 
-	loadHistory := func() (interface{}, error) {
-
-		var (
-			all, entries []*history.Entry
-			err          error
-			seen         = map[string]bool{}
-		)
-
-		all, err = history.Recent(recentHistoryEntries)
-		if err != nil {
-			return nil, err
-		}
-
-		// Filter duplicates
-		for _, e := range all {
-			if seen[e.URL] {
-				continue
-			}
-			entries = append(entries, e)
-			seen[e.URL] = true
-		}
-		log.Printf("removed %d duplicates from History", len(all)-len(entries))
-		return entries, nil
-	}
-
-	if err := wf.Session.LoadOrStoreJSON("history", loadHistory, &entries); err != nil {
+	if entries, err = recentHistory(); err != nil {
 		return err
 	}
 
 	log.Printf("loaded %d history items in %v", len(entries), time.Now().Sub(start))
 
+	urls := map[*aw.Item]string{}
 	for _, bm := range bms {
-		URLerItem(&bmURLer{bm})
+		urls[bookmarkItem(bm)] = bm.URL
 	}
 
+	// History entries don't have tags or folders
 	for _, e := range entries {
-		URLerItem(&hURLer{e})
+		if sq.MatchHistory(e) {
+			urls[historyItem(e)] = e.URL
+		}
 	}
 
-	if query != "" {
-		res := wf.Filter(query)
-		log.Printf("%d result(s) for %q", len(res), query)
+	if q != "" {
+		res := wf.Filter(q)
+		log.Printf("%d result(s) for %q", len(res), q)
+		scores := make([]float64, len(res))
 		for i, r := range res {
 			log.Printf("#%02d %5.2f %q", i+1, r.Score, r.SortKey)
+			scores[i] = r.Score
 		}
+		rankByFrecency(scores, urls)
 	}
 
 	wf.WarnEmpty("No matches found", "Try a different query?")
@@ -0,0 +1,190 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	aw "github.com/deanishe/awgo"
+	"github.com/deanishe/awgo/util"
+)
+
+// Prefix of smart folder UIDs.
+const smartFolderPrefix = "smart:"
+
+var (
+	smartFoldersFilename = "smart-folders.txt"
+	smartFoldersTemplate = `#
+# Smart folders
+# -------------
+#
+# Smart folders are saved searches that are shown at the top of the
+# list of bookmark folders. Each line defines one smart folder as
+#
+# Name = query
+#
+# The query is matched against your bookmarks, Reading List and recent
+# history. Words must all appear in an item's title or URL. The query
+# may also contain:
+#
+# host:example.com    Items from example.com (and its subdomains)
+# in:Work             Bookmarks in folder "Work" (or any of its subfolders)
+# in:"Bookmarks Menu/My Stuff"
+#                     Use quotes if the folder name contains spaces
+# #tag                Bookmarks with #tag in their title
+# type:bookmark       Only bookmarks. Other types are "reading-list"
+#                     and "history". Repeat to include several types.
+#
+# For example:
+#
+# GitHub PRs = host:github.com /pull/ type:bookmark
+#
+# Empty lines and lines beginning with # are ignored.
+#
+
+`
+)
+
+// smartFolder is a saved search.
+type smartFolder struct {
+	Name  string
+	Query *searchQuery
+}
+
+// UID returns the smart folder's UID for ALSF_UID.
+func (sf *smartFolder) UID() string { return smartFolderPrefix + sf.Name }
+
+// initSmartFolders returns path to the initialised smart folders file.
+func initSmartFolders() (string, error) {
+	path := filepath.Join(wf.DataDir(), smartFoldersFilename)
+	if !util.PathExists(path) {
+		if err := ioutil.WriteFile(path, []byte(smartFoldersTemplate), 0600); err != nil {
+			return "", err
+		}
+	}
+	return path, nil
+}
+
+// loadSmartFolders reads the user's smart folders.
+func loadSmartFolders() ([]*smartFolder, error) {
+	path, err := initSmartFolders()
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var folders []*smartFolder
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i < 1 {
+			log.Printf("invalid smart folder: %q", line)
+			continue
+		}
+		folders = append(folders, &smartFolder{
+			Name:  strings.TrimSpace(line[:i]),
+			Query: parseQuery(strings.TrimSpace(line[i+1:])),
+		})
+	}
+	return folders, scanner.Err()
+}
+
+// smartFolderForUID returns the smart folder with the given UID or nil.
+func smartFolderForUID(uid string) (*smartFolder, error) {
+	folders, err := loadSmartFolders()
+	if err != nil {
+		return nil, err
+	}
+	for _, sf := range folders {
+		if sf.UID() == uid {
+			return sf, nil
+		}
+	}
+	return nil, nil
+}
+
+// smartFolderItem returns a feedback Item for a smart folder.
+func smartFolderItem(sf *smartFolder) *aw.Item {
+	return wf.NewItem(sf.Name).
+		Subtitle("Smart Folder · " + sf.Query.String()).
+		Match(sf.Name).
+		UID(sf.UID()).
+		Icon(IconSmartFolder).
+		Valid(true).
+		Var("ALSF_UID", sf.UID()).
+		Var("action", "browse")
+}
+
+// doFilterSmartFolder shows the results of a smart folder.
+func doFilterSmartFolder() error {
+
+	sf, err := smartFolderForUID(uid)
+	if err != nil {
+		return err
+	}
+	if sf == nil {
+		return fmt.Errorf("No smart folder found with UID: %s", uid)
+	}
+	log.Printf("smart folder %q: %s", sf.Name, sf.Query)
+
+	if query == "" {
+		wf.Configure(aw.SuppressUIDs(true))
+		wf.NewItem("Back to All Folders").
+			Valid(true).
+			Icon(IconHome).
+			Var("action", "top")
+	}
+
+	bms, err := indexBookmarks(func(bm *indexBookmark) bool {
+		return !bm.Bookmarklet && sf.Query.MatchBookmark(bm) &&
+			sf.Query.matchText(bm.Title, bm.URL)
+	})
+	if err != nil {
+		return err
+	}
+	for _, bm := range bms {
+		bookmarkItem(bm)
+	}
+
+	n := len(bms)
+	if sf.Query.wantType(sourceHistory) {
+		entries, err := recentHistory()
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if sf.Query.MatchHistory(e) && sf.Query.matchText(e.Title, e.URL) {
+				historyItem(e)
+				n++
+			}
+		}
+	}
+	log.Printf("%d item(s) in smart folder %q", n, sf.Name)
+
+	if query != "" {
+		res := wf.Filter(query)
+		log.Printf("%d result(s) for %q", len(res), query)
+		for i, r := range res {
+			log.Printf("#%02d %5.2f %q", i+1, r.Score, r.SortKey)
+		}
+	}
+
+	wf.WarnEmpty("No matching items found", "Try a different query?")
+	wf.SendFeedback()
+	return nil
+}
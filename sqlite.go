@@ -0,0 +1,28 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"database/sql"
+	"net/url"
+	"path/filepath"
+
+	_ "github.com/mattn/go-sqlite3" // register driver
+)
+
+// openSQLite opens the SQLite database at path. If readOnly is true,
+// the database is opened read-only. It is not opened immutable, as
+// Safari's databases use WAL and recent changes may only be in the
+// -wal file.
+func openSQLite(path string, readOnly bool) (*sql.DB, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	u := &url.URL{Scheme: "file", Path: path}
+	if readOnly {
+		u.RawQuery = "mode=ro"
+	}
+	return sql.Open("sqlite3", u.String())
+}
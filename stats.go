@@ -0,0 +1,419 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	aw "github.com/deanishe/awgo"
+	"github.com/deanishe/go-safari/history"
+	"github.com/pkg/errors"
+)
+
+// Report formats.
+const (
+	reportMarkdown = "markdown"
+	reportHTML     = "html"
+)
+
+// Number of top sites and pages to show.
+const statsTopN = 10
+
+// Matches a range such as "7d" or "2w".
+var statsRangeRx = regexp.MustCompile(`^(\d+)([dw])$`)
+
+// browsingStats summarises browsing history over a period.
+type browsingStats struct {
+	From, To time.Time
+	Visits   int
+	Sites    int
+	Pages    int
+	TopSites []*hostVisits
+	TopPages []*pageVisits
+	NewSites []*hostVisits // first visited in the last week
+	Days     []dayCount    // every day in the period, oldest first
+	Hours    [24]int       // visits by hour of day
+	Tabs     int
+	Windows  int
+}
+
+// dayCount is the number of visits on a day.
+type dayCount struct {
+	Day   time.Time
+	Count int
+}
+
+// BusiestDay returns the day with the most visits.
+func (st *browsingStats) BusiestDay() dayCount {
+	var busiest dayCount
+	for _, d := range st.Days {
+		if d.Count > busiest.Count {
+			busiest = d
+		}
+	}
+	return busiest
+}
+
+// BusiestHour returns the hour of the day with the most visits.
+func (st *browsingStats) BusiestHour() int {
+	busiest := 0
+	for h, n := range st.Hours {
+		if n > st.Hours[busiest] {
+			busiest = h
+		}
+	}
+	return busiest
+}
+
+// Period describes the period the stats cover.
+func (st *browsingStats) Period() string {
+	return fmt.Sprintf("%s – %s", st.From.Format("2 Jan"), st.To.Format("2 Jan 2006"))
+}
+
+// doStats shows browsing statistics in Alfred or writes them as a report.
+func doStats() error {
+
+	log.Printf("range=%s, report=%q, output=%q", statsRange, statsReport, statsOutput)
+
+	if statsReport != "" {
+		wf.Configure(aw.TextErrors(true))
+	}
+
+	days, err := parseStatsRange(statsRange)
+	if err != nil {
+		return err
+	}
+	st, err := computeStats(days, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if statsReport == "" {
+		return statsItems(st)
+	}
+
+	var w io.Writer = os.Stdout
+	if statsOutput != "" && statsOutput != "-" {
+		f, err := os.Create(statsOutput)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	switch statsReport {
+	case reportMarkdown:
+		err = writeStatsMarkdown(w, st)
+	case reportHTML:
+		err = writeStatsHTML(w, st)
+	default:
+		err = fmt.Errorf("Unknown report format: %s", statsReport)
+	}
+	return errors.Wrap(err, "write report")
+}
+
+// parseStatsRange parses a range such as "7d" or "2w" into a number of days.
+func parseStatsRange(s string) (int, error) {
+	m := statsRangeRx.FindStringSubmatch(strings.ToLower(s))
+	if m == nil {
+		return 0, fmt.Errorf("Invalid range: %q", s)
+	}
+	n, _ := strconv.Atoi(m[1])
+	if m[2] == "w" {
+		n *= 7
+	}
+	if n < 1 {
+		return 0, fmt.Errorf("Invalid range: %q", s)
+	}
+	return n, nil
+}
+
+// computeStats calculates statistics for the days up to and including now.
+func computeStats(days int, now time.Time) (*browsingStats, error) {
+
+	var (
+		today   = startOfDay(now)
+		dr      = dateRange{From: today.AddDate(0, 0, 1-days)}
+		weekAgo = today.AddDate(0, 0, -6)
+		st      = &browsingStats{From: dr.From, To: now}
+	)
+
+	// Sites and pages
+	pages, err := visitsByURL(dr, "")
+	if err != nil {
+		return nil, err
+	}
+	hosts := groupByHost(pages)
+	st.Sites, st.Pages = len(hosts), len(pages)
+	st.TopSites = hosts
+	if len(hosts) > statsTopN {
+		st.TopSites = hosts[:statsTopN]
+	}
+	sort.SliceStable(pages, func(i, j int) bool { return pages[i].Count > pages[j].Count })
+	st.TopPages = pages
+	if len(pages) > statsTopN {
+		st.TopPages = pages[:statsTopN]
+	}
+
+	// New sites: those whose first visit ever was in the last week
+	first, err := firstVisits()
+	if err != nil {
+		return nil, err
+	}
+	if pages, err = visitsByURL(dateRange{From: weekAgo}, ""); err != nil {
+		return nil, err
+	}
+	old := map[string]bool{}
+	for URL, t := range first {
+		if t.Before(weekAgo) {
+			if u, err := url.Parse(URL); err == nil {
+				old[strings.ToLower(u.Hostname())] = true
+			}
+		}
+	}
+	for _, hv := range groupByHost(pages) {
+		if !old[hv.Host] {
+			st.NewSites = append(st.NewSites, hv)
+		}
+	}
+
+	// Visits by day and hour
+	times, err := visitTimes(dr)
+	if err != nil {
+		return nil, err
+	}
+	st.Visits = len(times)
+	byDay := map[time.Time]int{}
+	for _, t := range times {
+		t = t.Local()
+		byDay[startOfDay(t)]++
+		st.Hours[t.Hour()]++
+	}
+	for d := dr.From; !d.After(today); d = d.AddDate(0, 0, 1) {
+		st.Days = append(st.Days, dayCount{Day: d, Count: byDay[d]})
+	}
+
+	// Open tabs
+	if wins, err := loadWindows(); err != nil {
+		log.Printf("couldn't load windows: %v", err)
+	} else {
+		st.Windows = len(wins)
+		for _, w := range wins {
+			st.Tabs += len(w.Tabs)
+		}
+	}
+
+	log.Printf("%d visit(s) to %d page(s) on %d site(s), %d new site(s)",
+		st.Visits, st.Pages, st.Sites, len(st.NewSites))
+	return st, nil
+}
+
+// statsItems sends statistics to Alfred.
+func statsItems(st *browsingStats) error {
+
+	showUpdateStatus()
+
+	// Keep items in the order they're added
+	wf.Configure(aw.SuppressUIDs(true))
+
+	wf.NewItem(fmt.Sprintf("%s in the last %s", plural(st.Visits, "visit"), plural(len(st.Days), "day"))).
+		Subtitle(fmt.Sprintf("%s · %s · %s open in %s",
+			plural(st.Sites, "site"), plural(st.Pages, "page"),
+			plural(st.Tabs, "tab"), plural(st.Windows, "window"))).
+		Match("summary total").
+		Icon(IconHistory).
+		Valid(false)
+
+	if st.Visits > 0 {
+		d, h := st.BusiestDay(), st.BusiestHour()
+		wf.NewItem("Busiest day: " + d.Day.Format("Monday 2 January")).
+			Subtitle(plural(d.Count, "visit")).
+			Icon(IconHistory).
+			Valid(false)
+		wf.NewItem(fmt.Sprintf("Busiest hour: %02d:00–%02d:00", h, (h+1)%24)).
+			Subtitle(plural(st.Hours[h], "visit")).
+			Icon(IconHistory).
+			Valid(false)
+	}
+
+	for i, hv := range st.TopSites {
+		wf.NewItem(fmt.Sprintf("%d. %s", i+1, hv.Host)).
+			Subtitle(fmt.Sprintf("Top site · %s · %s", plural(hv.Count, "visit"), plural(hv.Pages, "page"))).
+			Match("top site " + hv.Host).
+			Icon(IconHistory).
+			Valid(false)
+	}
+
+	for i, p := range st.TopPages {
+		e := &history.Entry{URL: p.URL, Title: p.Title, Time: p.LastVisit}
+		historyItem(e).
+			Title(fmt.Sprintf("%d. %s", i+1, pageTitle(p))).
+			Subtitle(fmt.Sprintf("Top page · %s · %s", plural(p.Count, "visit"), p.URL))
+	}
+
+	for _, hv := range st.NewSites {
+		wf.NewItem(hv.Host).
+			Subtitle(fmt.Sprintf("New this week · %s · Last visited %s",
+				plural(hv.Count, "visit"), relativeTime(hv.LastVisit))).
+			Match("new site " + hv.Host).
+			Icon(IconHistory).
+			Valid(false)
+	}
+
+	most := st.BusiestDay().Count
+	for i := len(st.Days) - 1; i >= 0; i-- {
+		d := st.Days[i]
+		wf.NewItem(d.Day.Format("Mon 2 Jan")).
+			Subtitle(fmt.Sprintf("%s %s", bar(d.Count, most, 20), plural(d.Count, "visit"))).
+			Match("day " + d.Day.Format("Monday 2 January")).
+			Icon(IconHistory).
+			Valid(false)
+	}
+
+	if query != "" {
+		res := wf.Filter(query)
+		log.Printf("%d stat(s) for %q", len(res), query)
+	}
+
+	wf.WarnEmpty("No statistics found", "Try a different query?")
+	wf.SendFeedback()
+	return nil
+}
+
+// writeStatsMarkdown writes statistics as a Markdown report.
+func writeStatsMarkdown(w io.Writer, st *browsingStats) error {
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# Browsing digest: %s\n\n", st.Period())
+	fmt.Fprintf(&b, "%s to %s on %s. %s open in %s.\n\n",
+		plural(st.Visits, "visit"), plural(st.Pages, "page"), plural(st.Sites, "site"),
+		plural(st.Tabs, "tab"), plural(st.Windows, "window"))
+
+	b.WriteString("## Top sites\n\n| # | Site | Visits | Pages |\n|--:|------|-------:|------:|\n")
+	for i, hv := range st.TopSites {
+		fmt.Fprintf(&b, "| %d | %s | %d | %d |\n", i+1, hv.Host, hv.Count, hv.Pages)
+	}
+
+	b.WriteString("\n## Top pages\n\n")
+	for i, p := range st.TopPages {
+		fmt.Fprintf(&b, "%d. [%s](%s) (%s)\n", i+1, markdownEscape(pageTitle(p)), p.URL, plural(p.Count, "visit"))
+	}
+
+	b.WriteString("\n## New sites this week\n\n")
+	if len(st.NewSites) == 0 {
+		b.WriteString("None.\n")
+	}
+	for _, hv := range st.NewSites {
+		fmt.Fprintf(&b, "- %s (%s)\n", hv.Host, plural(hv.Count, "visit"))
+	}
+
+	most := st.BusiestDay().Count
+	b.WriteString("\n## Visits per day\n\n| Day | Visits | |\n|-----|-------:|-|\n")
+	for _, d := range st.Days {
+		fmt.Fprintf(&b, "| %s | %d | %s |\n", d.Day.Format("Mon 2 Jan"), d.Count, bar(d.Count, most, 20))
+	}
+
+	most = st.Hours[st.BusiestHour()]
+	b.WriteString("\n## Visits per hour\n\n| Hour | Visits | |\n|------|-------:|-|\n")
+	for h, n := range st.Hours {
+		fmt.Fprintf(&b, "| %02d:00 | %d | %s |\n", h, n, bar(n, most, 20))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeStatsHTML writes statistics as an HTML report.
+func writeStatsHTML(w io.Writer, st *browsingStats) error {
+	return statsTemplate.Execute(w, st)
+}
+
+var statsTemplate = template.Must(template.New("stats").Funcs(template.FuncMap{
+	"plural": plural,
+	"title":  pageTitle,
+	"inc":    func(i int) int { return i + 1 },
+	"pct": func(n, total int) int {
+		if total == 0 {
+			return 0
+		}
+		return n * 100 / total
+	},
+	"maxDay": func(st *browsingStats) int { return st.BusiestDay().Count },
+	"maxHour": func(st *browsingStats) int {
+		return st.Hours[st.BusiestHour()]
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Browsing digest: {{ .Period }}</title>
+<style>
+body { font-family: -apple-system, sans-serif; max-width: 50em; margin: 2em auto; color: #333; }
+table { border-collapse: collapse; }
+td, th { padding: 0.2em 0.6em; text-align: left; }
+td.n { text-align: right; }
+.bar { background: #00a1de; height: 0.8em; }
+</style>
+</head>
+<body>
+<h1>Browsing digest: {{ .Period }}</h1>
+<p>{{ plural .Visits "visit" }} to {{ plural .Pages "page" }} on {{ plural .Sites "site" }}.
+{{ plural .Tabs "tab" }} open in {{ plural .Windows "window" }}.</p>
+
+<h2>Top sites</h2>
+<table>
+<tr><th>#</th><th>Site</th><th>Visits</th><th>Pages</th></tr>
+{{ range $i, $h := .TopSites }}<tr><td>{{ inc $i }}</td><td>{{ $h.Host }}</td><td class="n">{{ $h.Count }}</td><td class="n">{{ $h.Pages }}</td></tr>
+{{ end }}</table>
+
+<h2>Top pages</h2>
+<ol>
+{{ range .TopPages }}<li><a href="{{ .URL }}">{{ title . }}</a> ({{ plural .Count "visit" }})</li>
+{{ end }}</ol>
+
+<h2>New sites this week</h2>
+{{ if .NewSites }}<ul>
+{{ range .NewSites }}<li>{{ .Host }} ({{ plural .Count "visit" }})</li>
+{{ end }}</ul>{{ else }}<p>None.</p>{{ end }}
+
+<h2>Visits per day</h2>
+<table>
+{{ $maxDay := maxDay . }}{{ range .Days }}<tr><td>{{ .Day.Format "Mon 2 Jan" }}</td><td class="n">{{ .Count }}</td><td style="width: 20em"><div class="bar" style="width: {{ pct .Count $maxDay }}%"></div></td></tr>
+{{ end }}</table>
+
+<h2>Visits per hour</h2>
+<table>
+{{ $maxHour := maxHour . }}{{ range $h, $n := .Hours }}<tr><td>{{ printf "%02d:00" $h }}</td><td class="n">{{ $n }}</td><td style="width: 20em"><div class="bar" style="width: {{ pct $n $maxHour }}%"></div></td></tr>
+{{ end }}</table>
+</body>
+</html>
+`))
+
+// pageTitle returns the page's title or its URL if it has none.
+func pageTitle(p *pageVisits) string {
+	if p.Title != "" {
+		return p.Title
+	}
+	return p.URL
+}
+
+// bar returns a text bar of n relative to total.
+func bar(n, total, width int) string {
+	if total == 0 {
+		return ""
+	}
+	return strings.Repeat("█", n*width/total)
+}
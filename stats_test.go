@@ -0,0 +1,98 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseStatsRange(t *testing.T) {
+	tests := []struct {
+		in string
+		x  int
+		ok bool
+	}{
+		{"7d", 7, true},
+		{"1d", 1, true},
+		{"2w", 14, true},
+		{"2W", 14, true},
+		{"0d", 0, false},
+		{"7", 0, false},
+		{"1m", 0, false},
+		{"", 0, false},
+	}
+	for _, td := range tests {
+		n, err := parseStatsRange(td.in)
+		if (err == nil) != td.ok || n != td.x {
+			t.Errorf("Bad range for %q. Expected=%d/%v, Got=%d/%v", td.in, td.x, td.ok, n, err)
+		}
+	}
+}
+
+func TestComputeStats(t *testing.T) {
+	// Visits in History.sql are between 12 and 16 Sep 2019 (UTC)
+	prev := time.Local
+	time.Local = time.UTC
+	defer func() { time.Local = prev }()
+
+	var (
+		now = time.Date(2019, 9, 16, 12, 0, 0, 0, time.UTC)
+		day = func(d int) time.Time { return time.Date(2019, 9, d, 0, 0, 0, 0, time.UTC) }
+	)
+
+	withHistoryDB(t, func(_ string) {
+		st, err := computeStats(7, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !st.From.Equal(day(10)) || !st.To.Equal(now) {
+			t.Errorf("Bad period. Expected=%v–%v, Got=%v–%v", day(10), now, st.From, st.To)
+		}
+		if st.Visits != 7 || st.Pages != 5 || st.Sites != 5 {
+			t.Errorf("Bad totals. Expected=7/5/5, Got=%d/%d/%d", st.Visits, st.Pages, st.Sites)
+		}
+
+		var days []int
+		for _, d := range st.Days {
+			days = append(days, d.Count)
+		}
+		if x := []int{0, 0, 2, 2, 0, 2, 1}; !reflect.DeepEqual(days, x) {
+			t.Errorf("Bad days. Expected=%v, Got=%v", x, days)
+		}
+		if d := st.BusiestDay(); !d.Day.Equal(day(12)) || d.Count != 2 {
+			t.Errorf("Bad busiest day. Expected=%v/2, Got=%v/%d", day(12), d.Day, d.Count)
+		}
+		if h := st.BusiestHour(); h != 0 || st.Hours[h] != 2 {
+			t.Errorf("Bad busiest hour. Expected=0/2, Got=%d/%d", h, st.Hours[h])
+		}
+		if st.TopSites[0].Host != "golang.org" || st.TopPages[0].URL != "https://golang.org/" {
+			t.Errorf("Bad top site/page: %s, %s", st.TopSites[0].Host, st.TopPages[0].URL)
+		}
+		if len(st.NewSites) != 5 {
+			t.Errorf("Bad new sites. Expected=5, Got=%d", len(st.NewSites))
+		}
+
+		// Only the last 2 days
+		if st, err = computeStats(2, now); err != nil {
+			t.Fatal(err)
+		}
+		if st.Visits != 3 || st.Pages != 3 || st.Sites != 3 || len(st.Days) != 2 {
+			t.Errorf("Bad 2-day totals. Expected=3/3/3/2, Got=%d/%d/%d/%d",
+				st.Visits, st.Pages, st.Sites, len(st.Days))
+		}
+		// Sites first visited more than a week ago aren't new
+		if st, err = computeStats(1, now.AddDate(0, 0, 4)); err != nil {
+			t.Fatal(err)
+		}
+		var hosts []string
+		for _, hv := range st.NewSites {
+			hosts = append(hosts, hv.Host)
+		}
+		if x := []string{"example.com", "t.co"}; !reflect.DeepEqual(hosts, x) {
+			t.Errorf("Bad new sites. Expected=%v, Got=%v", x, hosts)
+		}
+	})
+}
@@ -229,8 +229,12 @@ func customTabActions(it *aw.Item) *aw.Item {
 		// Name:Type map
 		actions = map[string]string{}
 	)
-	for _, bm := range safari.FilterBookmarks(func(bm *safari.Bookmark) bool { return bm.IsBookmarklet() }) {
-		bkms[bm.UID()] = bm.Title()
+	bms, err := indexBookmarks(func(bm *indexBookmark) bool { return bm.Bookmarklet })
+	if err != nil {
+		log.Printf("couldn't load bookmarklets: %v", err)
+	}
+	for _, bm := range bms {
+		bkms[bm.UID] = bm.Title
 	}
 
 	for _, a := range TabActions() {
@@ -0,0 +1,137 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Matches #tags in bookmark titles and queries. A tag must be preceded
+// by whitespace or start the string, so URL fragments etc. are ignored.
+var tagRx = regexp.MustCompile(`(?:^|\s)#([\pL\pN_-]+)`)
+
+// doFilterTags lists tags and their bookmarks. With no tags in the query,
+// it shows all tags; otherwise it shows the bookmarks with those tags.
+func doFilterTags() error {
+
+	showUpdateStatus()
+
+	rest, tags := parseTagQuery(query)
+	log.Printf("query=%q, tags=%v", rest, tags)
+
+	bms, err := indexBookmarks(func(bm *indexBookmark) bool { return !bm.Bookmarklet && !bm.ReadingList })
+	if err != nil {
+		return err
+	}
+
+	if len(tags) > 0 {
+		for _, bm := range filterTagged(bms, tags) {
+			bookmarkItem(bm)
+		}
+		if rest != "" {
+			res := wf.Filter(rest)
+			log.Printf("%d bookmark(s) for %q", len(res), rest)
+		}
+		wf.WarnEmpty("No bookmarks found", "Try a different query?")
+		wf.SendFeedback()
+		return nil
+	}
+
+	counts := map[string]int{}
+	for _, bm := range bms {
+		for _, t := range bm.Tags {
+			counts[t]++
+		}
+	}
+	log.Printf("%d tag(s) in %d bookmarks", len(counts), len(bms))
+
+	names := make([]string, 0, len(counts))
+	for t := range counts {
+		names = append(names, t)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	for _, t := range names {
+		wf.NewItem("#" + t).
+			Subtitle(fmt.Sprintf("%d bookmark(s)", counts[t])).
+			Match(t).
+			UID("tag-" + t).
+			Autocomplete("#" + t + " ").
+			Icon(IconBookmark).
+			Valid(false)
+	}
+
+	if rest != "" {
+		res := wf.Filter(rest)
+		log.Printf("%d tag(s) for %q", len(res), rest)
+	}
+
+	wf.WarnEmpty("No tags found", "Add #tags to your bookmarks' titles")
+	wf.SendFeedback()
+	return nil
+}
+
+// parseTags returns title with any #tags removed and the (lowercase) tags.
+func parseTags(title string) (string, []string) {
+	var tags []string
+	for _, m := range tagRx.FindAllStringSubmatch(title, -1) {
+		tags = append(tags, strings.ToLower(m[1]))
+	}
+	if len(tags) == 0 {
+		return title, nil
+	}
+	title = strings.Join(strings.Fields(tagRx.ReplaceAllString(title, " ")), " ")
+	return title, tags
+}
+
+// parseTagQuery splits query into the search text and #tags.
+func parseTagQuery(query string) (string, []string) { return parseTags(query) }
+
+// hasTags returns true if have contains all of tags.
+func hasTags(have, tags []string) bool {
+	for _, t := range tags {
+		found := false
+		for _, h := range have {
+			if h == t {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// tagSubtitle prefixes a subtitle with tags.
+func tagSubtitle(tags []string, s string) string {
+	if len(tags) == 0 {
+		return s
+	}
+	return "#" + strings.Join(tags, " #") + " · " + s
+}
+
+// filterTagged returns the bookmarks that have all of tags.
+func filterTagged(bookmarks []*indexBookmark, tags []string) []*indexBookmark {
+	if len(tags) == 0 {
+		return bookmarks
+	}
+	var tagged []*indexBookmark
+	for _, bm := range bookmarks {
+		if hasTags(bm.Tags, tags) {
+			tagged = append(tagged, bm)
+		}
+	}
+	return tagged
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		in, title string
+		tags      []string
+	}{
+		{"", "", nil},
+		{"No tags here", "No tags here", nil},
+		{"#go Programming", "Programming", []string{"go"}},
+		{"The Go Blog #golang #News", "The Go Blog", []string{"golang", "news"}},
+		{"Dash  #go-lang\t#a_b  docs", "Dash docs", []string{"go-lang", "a_b"}},
+		{"C#sharp tips", "C#sharp tips", nil},
+		{"Café #français #日本", "Café", []string{"français", "日本"}},
+		{"https://example.com/#section", "https://example.com/#section", nil},
+		{"Docs https://example.com/#frag #ref", "Docs https://example.com/#frag", []string{"ref"}},
+		{"Issue #123", "Issue", []string{"123"}},
+		{"Just a # sign", "Just a # sign", nil},
+	}
+	for _, td := range tests {
+		title, tags := parseTags(td.in)
+		if title != td.title {
+			t.Errorf("parseTags(%q): Expected title=%q, Got=%q", td.in, td.title, title)
+		}
+		if !reflect.DeepEqual(tags, td.tags) {
+			t.Errorf("parseTags(%q): Expected tags=%q, Got=%q", td.in, td.tags, tags)
+		}
+	}
+}
+
+func TestFilterTagged(t *testing.T) {
+	var (
+		a   = &indexBookmark{Title: "A", Tags: []string{"go", "web"}}
+		b   = &indexBookmark{Title: "B", Tags: []string{"go"}}
+		c   = &indexBookmark{Title: "C"}
+		all = []*indexBookmark{a, b, c}
+	)
+	tests := []struct {
+		tags []string
+		x    []*indexBookmark
+	}{
+		{nil, all},
+		{[]string{"go"}, []*indexBookmark{a, b}},
+		{[]string{"go", "web"}, []*indexBookmark{a}},
+		{[]string{"web", "go"}, []*indexBookmark{a}},
+		{[]string{"rust"}, nil},
+	}
+	for _, td := range tests {
+		if v := filterTagged(all, td.tags); !reflect.DeepEqual(v, td.x) {
+			t.Errorf("filterTagged(%q): Expected %d, Got %d", td.tags, len(td.x), len(v))
+		}
+	}
+}
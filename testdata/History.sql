@@ -0,0 +1,21 @@
+-- Subset of Safari's History.db schema with a few pages. Times are
+-- seconds since 2001-01-01. Visit 5 (t.co) redirected to visit 6.
+CREATE TABLE history_items (id INTEGER PRIMARY KEY AUTOINCREMENT, url TEXT NOT NULL UNIQUE, domain_expansion TEXT NULL, visit_count INTEGER NOT NULL, daily_visit_counts BLOB NOT NULL DEFAULT x'', weekly_visit_counts BLOB NULL, autocomplete_triggers BLOB NULL, should_recompute_derived_visit_counts INTEGER NOT NULL DEFAULT 0, visit_count_score INTEGER NOT NULL DEFAULT 0);
+CREATE TABLE history_visits (id INTEGER PRIMARY KEY AUTOINCREMENT, history_item INTEGER NOT NULL REFERENCES history_items(id) ON DELETE CASCADE, visit_time REAL NOT NULL, title TEXT NULL, load_successful BOOLEAN NOT NULL DEFAULT 1, http_non_get BOOLEAN NOT NULL DEFAULT 0, synthesized BOOLEAN NOT NULL DEFAULT 0, redirect_source INTEGER NULL UNIQUE REFERENCES history_visits(id) ON DELETE CASCADE, redirect_destination INTEGER NULL UNIQUE REFERENCES history_visits(id) ON DELETE CASCADE, origin INTEGER NOT NULL DEFAULT 0, generation INTEGER NOT NULL DEFAULT 0, attributes INTEGER NOT NULL DEFAULT 0, score INTEGER NOT NULL DEFAULT 0);
+CREATE TABLE history_tombstones (id INTEGER PRIMARY KEY AUTOINCREMENT, start_time REAL NOT NULL, end_time REAL NOT NULL, url TEXT, generation INTEGER NOT NULL DEFAULT 0);
+
+INSERT INTO history_items (id, url, domain_expansion, visit_count) VALUES
+	(1, 'https://golang.org/', 'golang', 2),
+	(2, 'https://blog.golang.org/go1.13', 'blog.golang', 1),
+	(3, 'https://t.co/abc123', 't', 1),
+	(4, 'https://www.example.com/', 'example', 2),
+	(5, 'https://example.com/page', 'example', 1);
+
+INSERT INTO history_visits (id, history_item, visit_time, title, redirect_source, redirect_destination) VALUES
+	(1, 1, 590000000.0, 'The Go Programming Language', NULL, NULL),
+	(2, 1, 590100000.0, 'The Go Programming Language', NULL, NULL),
+	(3, 2, 590050000.0, 'Go 1.13 is released', NULL, NULL),
+	(4, 4, 590020000.0, 'Example Domain', NULL, NULL),
+	(5, 3, 590200000.0, NULL, NULL, 6),
+	(6, 4, 590200001.0, 'Example Domain', 5, NULL),
+	(7, 5, 590300000.0, 'Example Page', NULL, NULL);
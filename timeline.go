@@ -0,0 +1,219 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	aw "github.com/deanishe/awgo"
+	"github.com/deanishe/go-safari/history"
+)
+
+// Query tokens that select a timeline view.
+const onThisDayToken = "on-this-day"
+
+var dayTokenRx = regexp.MustCompile(`^day:(\d{4}-\d{2}-\d{2})$`)
+
+// Number of pages shown for each day in "On This Day".
+const onThisDayPages = 3
+
+// Number of days the timeline shows if the query has no date tokens.
+const timelineMaxDays = 90
+
+// doHistoryTimeline shows history by day. The query may contain
+// day:YYYY-MM-DD to show that day's visits or on-this-day to show
+// the same date in previous months and years. Otherwise, the last
+// timelineMaxDays days are shown, or the days selected by date tokens
+// in the query, e.g. since:2019-01-01. The rest of the query filters
+// the items.
+func doHistoryTimeline() error {
+
+	showUpdateStatus()
+
+	var (
+		now       = time.Now()
+		day       time.Time
+		onThisDay bool
+		words     []string
+	)
+	for _, tok := range strings.Fields(query) {
+		if m := dayTokenRx.FindStringSubmatch(tok); m != nil {
+			t, err := time.ParseInLocation("2006-01-02", m[1], time.Local)
+			if err == nil {
+				day = t
+				continue
+			}
+		}
+		if tok == onThisDayToken {
+			onThisDay = true
+			continue
+		}
+		words = append(words, tok)
+	}
+	text := strings.Join(words, " ")
+	log.Printf("query=%q, day=%v, onThisDay=%v", text, day, onThisDay)
+
+	// Keep Alfred from re-ordering items based on usage
+	wf.Configure(aw.SuppressUIDs(true))
+
+	var err error
+	switch {
+	case !day.IsZero():
+		err = timelineDay(day, text)
+	case onThisDay:
+		err = timelineOnThisDay(now, text)
+	default:
+		var dr dateRange
+		text, dr = parseDateQuery(text, now)
+		err = timelineDays(now, dr)
+	}
+	if err != nil {
+		return err
+	}
+
+	if text != "" {
+		res := wf.Filter(text)
+		log.Printf("%d result(s) for %q", len(res), text)
+	}
+
+	wf.WarnEmpty("No history found", "Try a different query?")
+	wf.SendFeedback()
+	return nil
+}
+
+// timelineDays adds an item for each day in dr with history, most recent
+// first. If dr is zero, the last timelineMaxDays days are shown.
+func timelineDays(now time.Time, dr dateRange) error {
+
+	if dr.IsZero() {
+		dr.From = startOfDay(now).AddDate(0, 0, 1-timelineMaxDays)
+	}
+	days, err := visitsByDay(dr, 0)
+	if err != nil {
+		return err
+	}
+
+	wf.NewItem("On This Day").
+		Subtitle("What you visited on " + now.Format("2 January") + " in previous months and years").
+		Autocomplete(onThisDayToken + " ").
+		Icon(IconHistory).
+		Valid(false)
+
+	for _, dv := range days {
+		dayItem(dv, daysAgo(dv.Day, now))
+	}
+	return nil
+}
+
+// timelineDay adds an item for each visit on day in chronological order.
+func timelineDay(day time.Time, text string) error {
+
+	visits, err := historyVisits(dateRange{From: day, To: day.AddDate(0, 0, 1)}, "")
+	if err != nil {
+		return err
+	}
+	log.Printf("%d visit(s) on %s", len(visits), day.Format("2006-01-02"))
+
+	if text == "" {
+		wf.NewItem("Back to Timeline").
+			Subtitle(day.Format("Monday 2 January 2006")).
+			Autocomplete("").
+			Icon(IconHome).
+			Valid(false)
+	}
+
+	var prev string
+	for _, hv := range visits {
+		if hv.URL == prev { // reload
+			continue
+		}
+		prev = hv.URL
+		e := &history.Entry{URL: hv.URL, Title: hv.Title, Time: hv.Time}
+		historyItem(e).Subtitle(hv.Time.Format("15:04") + " · " + hv.URL)
+	}
+	return nil
+}
+
+// timelineOnThisDay adds an item for each previous month and year with
+// visits on today's date, followed by the most visited pages of that day.
+func timelineOnThisDay(now time.Time, text string) error {
+
+	today := startOfDay(now)
+	days, err := visitsByDay(dateRange{To: today}, today.Day())
+	if err != nil {
+		return err
+	}
+
+	if text == "" {
+		wf.NewItem("Back to Timeline").
+			Subtitle("On This Day: " + today.Format("2 January")).
+			Autocomplete("").
+			Icon(IconHome).
+			Valid(false)
+	}
+
+	for _, dv := range days {
+		if dv.Day.Day() != today.Day() || !dv.Day.Before(today) {
+			continue
+		}
+		dayItem(dv, monthsAgo(dv.Day, today))
+
+		pages := append([]*pageVisits{}, dv.Pages...)
+		sort.SliceStable(pages, func(i, j int) bool { return pages[i].Count > pages[j].Count })
+		if len(pages) > onThisDayPages {
+			pages = pages[:onThisDayPages]
+		}
+		for _, p := range pages {
+			e := &history.Entry{URL: p.URL, Title: p.Title, Time: p.LastVisit}
+			historyItem(e).
+				Subtitle(fmt.Sprintf("%s · %s", plural(p.Count, "visit"), p.URL))
+		}
+	}
+	return nil
+}
+
+// dayItem adds an item for a day that drills down into its visits.
+func dayItem(dv *dayVisits, ago string) *aw.Item {
+	var hosts []string
+	for i, hv := range groupByHost(dv.Pages) {
+		if i == 3 {
+			break
+		}
+		hosts = append(hosts, hv.Host)
+	}
+	return wf.NewItem(dv.Day.Format("Monday 2 January 2006")).
+		Subtitle(fmt.Sprintf("%s · %s · %s · %s", ago, plural(dv.Count(), "visit"),
+			plural(len(dv.Pages), "page"), strings.Join(hosts, ", "))).
+		Autocomplete("day:" + dv.Day.Format("2006-01-02") + " ").
+		Icon(IconHistory).
+		Valid(false)
+}
+
+// daysAgo describes how many days day is before now.
+func daysAgo(day, now time.Time) string {
+	// Round to allow for DST changes
+	n := int(startOfDay(now).Sub(day).Hours()/24 + 0.5)
+	switch n {
+	case 0:
+		return "Today"
+	case 1:
+		return "Yesterday"
+	default:
+		return plural(n, "day") + " ago"
+	}
+}
+
+// monthsAgo describes how many months (or years) day is before today.
+func monthsAgo(day, today time.Time) string {
+	n := (today.Year()-day.Year())*12 + int(today.Month()-day.Month())
+	if n%12 == 0 {
+		return plural(n/12, "year") + " ago"
+	}
+	return plural(n, "month") + " ago"
+}
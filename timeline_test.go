@@ -0,0 +1,104 @@
+// Copyright (c) 2019 Dean Jackson <deanishe@deanishe.net>
+// MIT Licence applies http://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDaysAgo(t *testing.T) {
+	var (
+		now = time.Date(2019, 10, 16, 15, 4, 5, 0, time.UTC)
+		day = func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, 0, 0, 0, 0, time.UTC) }
+	)
+	tests := []struct {
+		day time.Time
+		x   string
+	}{
+		{day(2019, 10, 16), "Today"},
+		{day(2019, 10, 15), "Yesterday"},
+		{day(2019, 10, 14), "2 days ago"},
+		{day(2019, 9, 16), "30 days ago"},
+		{day(2018, 10, 16), "365 days ago"},
+	}
+	for _, td := range tests {
+		if v := daysAgo(td.day, now); v != td.x {
+			t.Errorf("Bad daysAgo for %s. Expected=%q, Got=%q", td.day.Format("2006-01-02"), td.x, v)
+		}
+	}
+
+	// Days are counted in calendar days across DST changes
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+	now = time.Date(2019, 10, 28, 9, 0, 0, 0, loc) // day after clocks went back
+	if v := daysAgo(time.Date(2019, 10, 26, 0, 0, 0, 0, loc), now); v != "2 days ago" {
+		t.Errorf("Bad daysAgo across DST. Expected=%q, Got=%q", "2 days ago", v)
+	}
+}
+
+func TestMonthsAgo(t *testing.T) {
+	var (
+		today = time.Date(2019, 10, 16, 0, 0, 0, 0, time.UTC)
+		day   = func(y int, m time.Month) time.Time { return time.Date(y, m, 16, 0, 0, 0, 0, time.UTC) }
+	)
+	tests := []struct {
+		day time.Time
+		x   string
+	}{
+		{day(2019, 9), "1 month ago"},
+		{day(2019, 1), "9 months ago"},
+		{day(2018, 11), "11 months ago"},
+		{day(2018, 10), "1 year ago"},
+		{day(2018, 9), "13 months ago"},
+		{day(2016, 10), "3 years ago"},
+	}
+	for _, td := range tests {
+		if v := monthsAgo(td.day, today); v != td.x {
+			t.Errorf("Bad monthsAgo for %s. Expected=%q, Got=%q", td.day.Format("2006-01"), td.x, v)
+		}
+	}
+}
+
+func TestVisitsByDay(t *testing.T) {
+	// Visits in History.sql are between 12 and 16 Sep 2019 (UTC)
+	prev := time.Local
+	time.Local = time.UTC
+	defer func() { time.Local = prev }()
+
+	day := func(d int) time.Time { return time.Date(2019, 9, d, 0, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		dr         dateRange
+		dayOfMonth int
+		x          []string // day: visits/pages
+	}{
+		{dateRange{}, 0, []string{"2019-09-16 1/1", "2019-09-15 2/2", "2019-09-13 2/2", "2019-09-12 2/2"}},
+		{dateRange{From: day(15)}, 0, []string{"2019-09-16 1/1", "2019-09-15 2/2"}},
+		{dateRange{From: day(13), To: day(15)}, 0, []string{"2019-09-13 2/2"}},
+		{dateRange{}, 12, []string{"2019-09-12 2/2"}},
+		{dateRange{To: day(12)}, 12, nil},
+		{dateRange{}, 1, nil},
+	}
+
+	withHistoryDB(t, func(_ string) {
+		for _, td := range tests {
+			days, err := visitsByDay(td.dr, td.dayOfMonth)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var v []string
+			for _, dv := range days {
+				v = append(v, fmt.Sprintf("%s %d/%d", dv.Day.Format("2006-01-02"), dv.Count(), len(dv.Pages)))
+			}
+			if !reflect.DeepEqual(v, td.x) {
+				t.Errorf("Bad days for %s, day %d. Expected=%q, Got=%q", td.dr, td.dayOfMonth, td.x, v)
+			}
+		}
+	})
+}
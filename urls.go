@@ -10,6 +10,7 @@ package main
 
 import (
 	"net/url"
+	"strings"
 
 	aw "github.com/deanishe/awgo"
 )
@@ -25,6 +26,11 @@ type URLer interface {
 	Icon() *aw.Icon
 }
 
+// keyworder is a URLer with precomputed search keys.
+type keyworder interface {
+	Keywords() string
+}
+
 // URLerItem returns a feedback Item for a URLer.
 func URLerItem(u URLer) *aw.Item {
 
@@ -45,7 +51,11 @@ func URLerItem(u URLer) *aw.Item {
 
 		if searchHostnames {
 			// Add hostname to search keys
-			it.Match(u.Title() + " " + URL.Hostname())
+			if k, ok := u.(keyworder); ok {
+				it.Match(k.Keywords())
+			} else {
+				it.Match(u.Title() + " " + URL.Hostname())
+			}
 		}
 
 		if URL.Scheme == "http" || URL.Scheme == "https" {
@@ -90,3 +100,32 @@ func URLerItem(u URLer) *aw.Item {
 	}
 	return it
 }
+
+// normaliseURL returns a canonical form of URL for detecting duplicates.
+// The scheme of web URLs, "www.", default ports, fragments and trailing
+// slashes are ignored.
+func normaliseURL(URL string) string {
+	u, err := url.Parse(strings.TrimSpace(URL))
+	if err != nil || u.Host == "" {
+		return strings.TrimSpace(URL)
+	}
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	if p := u.Port(); p != "" && p != "80" && p != "443" {
+		host += ":" + p
+	}
+	if scheme == "http" || scheme == "https" {
+		scheme = ""
+	}
+	s := scheme + "//" + host + strings.TrimSuffix(u.EscapedPath(), "/")
+	if u.RawQuery != "" {
+		s += "?" + u.RawQuery
+	}
+	return s
+}
+
+// isWebURL returns true if URL is an HTTP(S) URL.
+func isWebURL(URL string) bool {
+	u, err := url.Parse(URL)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https")
+}